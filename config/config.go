@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"sync/atomic"
 
 	"github.com/crazycs520/continuous-profile/util/logutil"
@@ -24,6 +25,14 @@ const (
 	DefProfileSeconds                = 5
 	DefProfilingTimeoutSeconds       = 120
 	DefProfilingDataRetentionSeconds = 3 * 24 * 60 * 60 // 3 days
+	DefTraceSeconds                  = 3
+	DefTraceIntervalSeconds          = 30 * 60
+	DefTraceMaxSize                  = 32 * 1024 * 1024 // 32MB
+	DefMaxProfileSize                = 64 * 1024 * 1024 // 64MB
+	DefMaxConcurrentScrapes          = 32
+	DefMaxHostHeavyScrapes           = 1
+	DefScrapeWorkers                 = 32
+	DefIngestMaxSize                 = 16 * 1024 * 1024 // 16MB
 )
 
 type Config struct {
@@ -34,8 +43,11 @@ type Config struct {
 	ConfigPath        string                  `yaml:"config_path" json:"config_path"`
 	PDAddr            string                  `yaml:"pd_address" json:"pd_address"`
 	Log               Log                     `yaml:"log" json:"log"`
-	ContinueProfiling ContinueProfilingConfig `yaml:"-" json:"continuous_profiling"`
+	ContinueProfiling ContinueProfilingConfig `yaml:"continuous_profiling" json:"continuous_profiling"`
 	Security          Security                `yaml:"security" json:"security"`
+	ScrapeConfigs     []*ScrapeConfig         `yaml:"scrape_configs" json:"scrape_configs"`
+	Ingest            IngestConfig            `yaml:"ingest" json:"ingest"`
+	Discovery         DiscoveryConfig         `yaml:"discovery" json:"discovery"`
 }
 
 var defaultConfig = Config{
@@ -48,19 +60,144 @@ var defaultConfig = Config{
 		IntervalSeconds:      DefProfilingIntervalSeconds,
 		TimeoutSeconds:       DefProfilingTimeoutSeconds,
 		DataRetentionSeconds: DefProfilingDataRetentionSeconds,
+		MaxProfileSize:       DefMaxProfileSize,
+		MaxConcurrentScrapes: DefMaxConcurrentScrapes,
+		MaxHostHeavyScrapes:  DefMaxHostHeavyScrapes,
+		ScrapeWorkers:        DefScrapeWorkers,
 	},
 	Log: Log{
 		Level:   "info",
 		MaxSize: logutil.DefaultLogMaxSize,
 	},
+	Ingest: IngestConfig{
+		MaxSize: DefIngestMaxSize,
+	},
 }
 
 type ContinueProfilingConfig struct {
-	Enable               bool `json:"enable"`
-	ProfileSeconds       int  `json:"profile_seconds"`
-	IntervalSeconds      int  `json:"interval_seconds"`
-	TimeoutSeconds       int  `json:"timeout_seconds"`
-	DataRetentionSeconds int  `json:"data_retention_seconds"`
+	Enable               bool `yaml:"enable" json:"enable"`
+	ProfileSeconds       int  `yaml:"profile_seconds" json:"profile_seconds"`
+	IntervalSeconds      int  `yaml:"interval_seconds" json:"interval_seconds"`
+	TimeoutSeconds       int  `yaml:"timeout_seconds" json:"timeout_seconds"`
+	DataRetentionSeconds int  `yaml:"data_retention_seconds" json:"data_retention_seconds"`
+	// MaxProfileSize is the default max size in bytes of a scraped profile, the oversize profile will be
+	// aborted. It can be overridden by the max_size of each profile kind.
+	MaxProfileSize int `yaml:"max_profile_size" json:"max_profile_size"`
+	// MaxConcurrentScrapes is the max number of the running scrapes, 0 means no limit.
+	MaxConcurrentScrapes int `yaml:"max_concurrent_scrapes" json:"max_concurrent_scrapes"`
+	// MaxHostHeavyScrapes is the max number of the running CPU-heavy scrapes, such as profile and trace,
+	// on the same host. The heavy scrapes skew each other when the components share a host. 0 means no limit.
+	MaxHostHeavyScrapes int `yaml:"max_host_heavy_scrapes" json:"max_host_heavy_scrapes"`
+	// Aligned means every target of a profile kind starts the scrape at the same interval boundary, and the
+	// profiles are tagged with the shared round, so the profiles of different instances cover the same window.
+	// The heavy scrapes on the same host are still limited by MaxHostHeavyScrapes, which delays them.
+	Aligned bool `yaml:"aligned" json:"aligned"`
+	// ScrapeWorkers is the number of the workers which run the due scrapes, it takes effect after restart.
+	ScrapeWorkers int `yaml:"scrape_workers" json:"scrape_workers"`
+	// Kinds overrides the schedule of the specified profile kind for all the components, the key is the profile kind.
+	// It doesn't apply to the scrape_configs, which have their own schedule.
+	Kinds map[string]KindScheduleConfig `yaml:"kinds,omitempty" json:"kinds"`
+	// Components overrides the profiling config of the specified component, the key is the component name.
+	Components map[string]ComponentProfilingConfig `yaml:"components,omitempty" json:"components"`
+}
+
+// KindScheduleConfig is the schedule of a profile kind, the zero value field means inherit from
+// the component level config.
+type KindScheduleConfig struct {
+	IntervalSeconds int `yaml:"interval_seconds,omitempty" json:"interval_seconds,omitempty"`
+	TimeoutSeconds  int `yaml:"timeout_seconds,omitempty" json:"timeout_seconds,omitempty"`
+}
+
+// ComponentProfilingConfig is the component level continuous profiling config,
+// the zero value field means inherit from the global continuous profiling config.
+type ComponentProfilingConfig struct {
+	Enable          *bool `yaml:"enable,omitempty" json:"enable,omitempty"`
+	ProfileSeconds  int   `yaml:"profile_seconds,omitempty" json:"profile_seconds,omitempty"`
+	IntervalSeconds int   `yaml:"interval_seconds,omitempty" json:"interval_seconds,omitempty"`
+	TimeoutSeconds  int   `yaml:"timeout_seconds,omitempty" json:"timeout_seconds,omitempty"`
+	// PprofConfig is merged into the built-in pprof config of the component, see GetPprofConfig.
+	PprofConfig PprofConfig `yaml:"pprof_config,omitempty" json:"pprof_config,omitempty"`
+}
+
+// ForComponent returns the effective continuous profiling config of the component.
+func (c ContinueProfilingConfig) ForComponent(component string) ContinueProfilingConfig {
+	cfg := c
+	cfg.Components = nil
+	override, ok := c.Components[component]
+	if !ok {
+		return cfg
+	}
+	if override.Enable != nil {
+		cfg.Enable = c.Enable && *override.Enable
+	}
+	if override.ProfileSeconds > 0 {
+		cfg.ProfileSeconds = override.ProfileSeconds
+	}
+	if override.IntervalSeconds > 0 {
+		cfg.IntervalSeconds = override.IntervalSeconds
+	}
+	if override.TimeoutSeconds > 0 {
+		cfg.TimeoutSeconds = override.TimeoutSeconds
+	}
+	return cfg
+}
+
+// GetPprofConfig returns the effective pprof config of the component. The profile kinds in the component's
+// pprof_config are merged into the built-in profile kinds, the zero value fields are inherited from the built-in
+// profile kind, and a profile kind with `enabled: false` will not be scraped. Then the schedules in kinds are
+// applied to both the built-in and the custom profile kinds, unless the component's pprof_config specifies them.
+func (c ContinueProfilingConfig) GetPprofConfig(component string) PprofConfig {
+	cfg := c.ForComponent(component)
+	pprofConfig := defaultPprofConfig(component, cfg.ProfileSeconds)
+	overrides := c.Components[component].PprofConfig
+	for kind, pc := range overrides {
+		if pc == nil {
+			continue
+		}
+		if base, ok := pprofConfig[kind]; ok {
+			pprofConfig[kind] = pc.merge(base)
+		} else {
+			kindCfg := *pc
+			pprofConfig[kind] = &kindCfg
+		}
+	}
+	for kind, schedule := range c.Kinds {
+		pc, ok := pprofConfig[kind]
+		if !ok {
+			continue
+		}
+		override := overrides[kind]
+		if schedule.IntervalSeconds > 0 && (override == nil || override.IntervalSeconds == 0) {
+			pc.IntervalSeconds = schedule.IntervalSeconds
+		}
+		if schedule.TimeoutSeconds > 0 && (override == nil || override.TimeoutSeconds == 0) {
+			pc.TimeoutSeconds = schedule.TimeoutSeconds
+		}
+	}
+	return pprofConfig
+}
+
+// componentNames returns the built-in components and the components in the components config.
+func (c ContinueProfilingConfig) componentNames() []string {
+	names := []string{"pd", "tidb", "tikv", "tiflash"}
+	for name := range c.Components {
+		switch name {
+		case "pd", "tidb", "tikv", "tiflash":
+		default:
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IngestConfig is the config of the push ingest API, which is used by the applications that can't be scraped.
+type IngestConfig struct {
+	Enable bool `yaml:"enable" json:"enable"`
+	// Tokens authenticates the push requests by the `Authorization: Bearer <token>` header.
+	Tokens []string `yaml:"tokens" json:"-"`
+	// MaxSize is the max size in bytes of the pushed profile after decompressed.
+	MaxSize int `yaml:"max_size" json:"max_size"`
 }
 
 var globalConf atomic.Value
@@ -88,6 +225,10 @@ func Initialize(configFile string, overrideConfig func(*Config)) error {
 	if overrideConfig != nil {
 		overrideConfig(cfg)
 	}
+	err = cfg.Validate()
+	if err != nil {
+		return err
+	}
 	StoreGlobalConfig(cfg)
 	return nil
 }
@@ -45,3 +45,188 @@ func TestReadConfigFile(t *testing.T) {
 	err = Initialize(configFile, nil)
 	require.Equal(t, err.Error(), "job tidb, profile.seconds(10) should less than the scrapscrape_timeout(10s)")
 }
+
+func TestValidateConfig(t *testing.T) {
+	cfg := NewConfig()
+	require.NoError(t, cfg.Validate())
+
+	cfg.Port = 0
+	cfg.PDAddr = "http://127.0.0.1:2379"
+	cfg.Security.SSLCA = "not-exist-ca.pem"
+	cfg.ContinueProfiling.IntervalSeconds = 0
+	cfg.ScrapeConfigs = []*ScrapeConfig{{
+		ComponentName: "sidecar",
+		Targets:       []string{"127.0.0.1"},
+	}}
+	cfg.Discovery.StaticConfigs = []*StaticDiscoveryConfig{{
+		Targets: []string{"127.0.0.1:6060"},
+	}}
+	cfg.Discovery.FileConfigs = []*FileDiscoveryConfig{{}, {Path: "targets/[.json"}}
+	err := cfg.Validate()
+	require.Error(t, err)
+	errs, ok := err.(ValidationErrors)
+	require.True(t, ok)
+	fields := make([]string, 0, len(errs))
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	require.Equal(t, []string{
+		"port",
+		"pd_address",
+		"security.ssl_ca",
+		"security",
+		"continuous_profiling.interval_seconds",
+		"discovery.static_configs[0].component_name",
+		"discovery.file_configs[0].path",
+		"discovery.file_configs[1].path",
+		"scrape_configs[0].targets[0]",
+	}, fields)
+}
+
+func TestContinueProfilingConfig(t *testing.T) {
+	configFile := "config.yaml"
+	writeIntoFile(t, configFile, `continuous_profiling:
+  interval_seconds: 30
+  profile_seconds: 10
+  components:
+    tikv:
+      profile_seconds: 20
+    pd:
+      enable: false`)
+	defer removeFile(t, configFile)
+	err := Initialize(configFile, nil)
+	require.NoError(t, err)
+	cfg := GetGlobalConfig().ContinueProfiling
+	require.True(t, cfg.Enable)
+	require.Equal(t, 30, cfg.IntervalSeconds)
+	require.Equal(t, DefProfilingTimeoutSeconds, cfg.TimeoutSeconds)
+
+	tidbCfg := cfg.ForComponent("tidb")
+	require.True(t, tidbCfg.Enable)
+	require.Equal(t, 10, tidbCfg.ProfileSeconds)
+	tikvCfg := cfg.ForComponent("tikv")
+	require.Equal(t, 20, tikvCfg.ProfileSeconds)
+	require.Equal(t, 30, tikvCfg.IntervalSeconds)
+	require.False(t, cfg.ForComponent("pd").Enable)
+
+	writeIntoFile(t, configFile, `continuous_profiling:
+  components:
+    tikv:
+      profile_seconds: 200`)
+	err = Initialize(configFile, nil)
+	require.Equal(t, "continuous_profiling.components.tikv.profile_seconds(200) should less than the continuous_profiling.components.tikv.timeout_seconds(120)", err.Error())
+}
+
+func TestGetPprofConfig(t *testing.T) {
+	cfg := NewConfig().ContinueProfiling
+	pprofConfig := cfg.GetPprofConfig("tidb")
+	require.Len(t, pprofConfig, 8)
+	require.Equal(t, DefProfileSeconds, pprofConfig["profile"].Seconds)
+	require.True(t, pprofConfig["block"].SkipEmpty)
+	require.False(t, pprofConfig["trace"].IsEnabled())
+	require.Len(t, cfg.GetPprofConfig("tikv"), 2)
+
+	disabled := false
+	cfg.Components = map[string]ComponentProfilingConfig{
+		"tidb": {
+			ProfileSeconds: 10,
+			PprofConfig: PprofConfig{
+				"goroutine": {Enabled: &disabled},
+				"fgprof":    {},
+				"mutex":     {Params: map[string]string{"debug": "1"}},
+			},
+		},
+	}
+	pprofConfig = cfg.GetPprofConfig("tidb")
+	require.Len(t, pprofConfig, 9)
+	require.False(t, pprofConfig["goroutine"].IsEnabled())
+	require.Equal(t, "/debug/pprof/fgprof", pprofConfig["fgprof"].GetPath("fgprof"))
+	require.Equal(t, "/debug/pprof/mutex", pprofConfig["mutex"].Path)
+	require.Equal(t, "1", pprofConfig["mutex"].Params["debug"])
+	require.Equal(t, 10, pprofConfig["profile"].Seconds)
+	// the built-in config should not be modified.
+	require.Equal(t, "2", cfg.GetPprofConfig("pd")["goroutine"].Params["debug"])
+
+	// the schedule of kinds applies to all the components, and the component's pprof_config has higher priority.
+	cfg.Kinds = map[string]KindScheduleConfig{
+		"mutex":   {IntervalSeconds: 300},
+		"profile": {IntervalSeconds: 60, TimeoutSeconds: 30},
+	}
+	cfg.Components["tidb"].PprofConfig["mutex"].IntervalSeconds = 600
+	pprofConfig = cfg.GetPprofConfig("tidb")
+	require.Equal(t, 600, pprofConfig["mutex"].IntervalSeconds)
+	require.Equal(t, 60, pprofConfig["profile"].IntervalSeconds)
+	require.Equal(t, 30, pprofConfig["profile"].TimeoutSeconds)
+	require.Equal(t, 0, pprofConfig["heap"].IntervalSeconds)
+	tikvConfig := cfg.GetPprofConfig("tikv")
+	require.Len(t, tikvConfig, 2)
+	require.Equal(t, 60, tikvConfig["profile"].IntervalSeconds)
+
+	cfg.Kinds["profile"] = KindScheduleConfig{TimeoutSeconds: 5}
+	c := NewConfig()
+	c.ContinueProfiling = cfg
+	require.Error(t, c.Validate())
+}
+
+func TestLocalSource(t *testing.T) {
+	cfg := NewConfig()
+	cfg.ContinueProfiling.Components = map[string]ComponentProfilingConfig{
+		"tidb": {PprofConfig: PprofConfig{
+			"x": {Source: "exec", Command: []string{"sh", "-c", "id"}},
+			"y": {Source: "file", Dir: "/etc"},
+		}},
+	}
+	err := cfg.Validate()
+	require.Error(t, err)
+	errs := err.(ValidationErrors)
+	require.Len(t, errs, 2)
+	require.Equal(t, "continuous_profiling.components.tidb.pprof_config.x.source", errs[0].Field)
+	require.Equal(t, "continuous_profiling.components.tidb.pprof_config.y.source", errs[1].Field)
+
+	// the local sources are allowed in the scrape_configs of the config file.
+	cfg = NewConfig()
+	cfg.ScrapeConfigs = []*ScrapeConfig{{
+		ComponentName: "tikv-perf",
+		Targets:       []string{"10.0.1.22:20180"},
+		ProfilingConfig: &ProfilingConfig{PprofConfig: PprofConfig{
+			"perf": {Source: "exec", Seconds: 10, Format: "perf", Command: []string{"perf", "record"}},
+		}},
+	}}
+	require.NoError(t, cfg.Validate())
+	cfg.ScrapeConfigs[0].ProfilingConfig.PprofConfig["perf"].Format = "../../perf"
+	err = cfg.Validate()
+	require.Error(t, err)
+	require.Equal(t, "scrape_configs[0].profiling_config.pprof_config.perf.format", err.(ValidationErrors)[0].Field)
+}
+
+func TestKindsSchedule(t *testing.T) {
+	cfg := NewConfig()
+	cfg.ContinueProfiling.Kinds = map[string]KindScheduleConfig{
+		"profile": {TimeoutSeconds: 15},
+		"fgprof":  {IntervalSeconds: 300},
+	}
+	cfg.ContinueProfiling.Components = map[string]ComponentProfilingConfig{
+		"tidb": {PprofConfig: PprofConfig{"fgprof": {}}},
+	}
+	require.NoError(t, cfg.Validate())
+	// the schedules in kinds apply to the custom profile kinds too.
+	require.Equal(t, 300, cfg.ContinueProfiling.GetPprofConfig("tidb")["fgprof"].IntervalSeconds)
+	require.Equal(t, 15, cfg.ContinueProfiling.GetPprofConfig("tidb")["profile"].TimeoutSeconds)
+
+	// the profile seconds of tikv is longer than the timeout of the kind.
+	cfg.ContinueProfiling.Components["tikv"] = ComponentProfilingConfig{ProfileSeconds: 20}
+	err := cfg.Validate()
+	require.Error(t, err)
+	errs := err.(ValidationErrors)
+	require.Len(t, errs, 1)
+	require.Equal(t, "continuous_profiling.kinds.profile.timeout_seconds", errs[0].Field)
+	require.Contains(t, errs[0].Message, "of tikv")
+
+	// the component's pprof_config has higher priority than kinds.
+	cfg.ContinueProfiling.Components["tikv"] = ComponentProfilingConfig{
+		ProfileSeconds: 20,
+		PprofConfig:    PprofConfig{"profile": {TimeoutSeconds: 30}},
+	}
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, 30, cfg.ContinueProfiling.GetPprofConfig("tikv")["profile"].TimeoutSeconds)
+}
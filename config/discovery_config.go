@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// DefFileDiscoveryRefreshInterval is the default interval to check the changes of the files of the file discovery.
+const DefFileDiscoveryRefreshInterval = 5 * time.Second
+
+// DiscoveryConfig configures the discovery providers besides the TiDB cluster topology of pd_address, all the
+// providers are composed. It takes effect after restart.
+type DiscoveryConfig struct {
+	// StaticConfigs is the static list of the components, they use the continuous profiling config of the component.
+	StaticConfigs []*StaticDiscoveryConfig `yaml:"static_configs,omitempty" json:"static_configs"`
+	// FileConfigs reads the components from the target group files, the files are reloaded once changed.
+	FileConfigs []*FileDiscoveryConfig `yaml:"file_configs,omitempty" json:"file_configs"`
+}
+
+// StaticDiscoveryConfig is a static list of the components.
+type StaticDiscoveryConfig struct {
+	ComponentName string `yaml:"component_name" json:"component_name"`
+	// Targets is the status address of the components, the format is host:port.
+	Targets []string          `yaml:"targets" json:"targets"`
+	Labels  map[string]string `yaml:"labels,omitempty" json:"labels"`
+}
+
+// FileDiscoveryConfig reads the components from the target group files in the Prometheus file_sd format.
+type FileDiscoveryConfig struct {
+	// Path is the path of the JSON or YAML files, it may be a glob pattern such as /etc/conprof/*.json.
+	Path string `yaml:"path" json:"path"`
+	// ComponentName is the component name of the target groups which don't specify the component.
+	ComponentName   string        `yaml:"component_name,omitempty" json:"component_name"`
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty" json:"refresh_interval"`
+}
+
+func (c *DiscoveryConfig) validate(v *validator) {
+	for i, sc := range c.StaticConfigs {
+		path := fmt.Sprintf("discovery.static_configs[%v]", i)
+		if sc == nil {
+			v.addError(path, "%v should not be empty", path)
+			continue
+		}
+		if sc.ComponentName == "" {
+			v.addError(path+".component_name", "%v.component_name should not be empty", path)
+		}
+		if len(sc.Targets) == 0 {
+			v.addError(path+".targets", "%v.targets should not be empty", path)
+		}
+		for j, target := range sc.Targets {
+			if !isValidAddress(target) {
+				v.addError(fmt.Sprintf("%v.targets[%v]", path, j),
+					"%v.targets[%v](%v) is invalid, the format should be host:port", path, j, target)
+			}
+		}
+		if _, ok := sc.Labels[""]; ok {
+			v.addError(path+".labels", "%v.labels, the label name should not be empty", path)
+		}
+	}
+	for i, fc := range c.FileConfigs {
+		path := fmt.Sprintf("discovery.file_configs[%v]", i)
+		if fc == nil {
+			v.addError(path, "%v should not be empty", path)
+			continue
+		}
+		if fc.Path == "" {
+			v.addError(path+".path", "%v.path should not be empty", path)
+		} else if _, err := filepath.Match(fc.Path, ""); err != nil {
+			v.addError(path+".path", "%v.path(%v) is an invalid pattern: %v", path, fc.Path, err)
+		}
+		if fc.RefreshInterval < 0 {
+			v.addError(path+".refresh_interval", "%v.refresh_interval(%v) should not be negative", path, fc.RefreshInterval)
+		}
+	}
+}
@@ -1,28 +1,182 @@
 package config
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // ScrapeConfig configures a scraping unit for conprof.
 type ScrapeConfig struct {
-	ComponentName string `yaml:"component_name,omitempty"`
+	ComponentName string `yaml:"component_name,omitempty" json:"component_name"`
 	// How frequently to scrape the targets of this scrape config.
-	ScrapeInterval time.Duration `yaml:"scrape_interval,omitempty"`
+	ScrapeInterval time.Duration `yaml:"scrape_interval,omitempty" json:"scrape_interval"`
 	// The timeout for scraping targets of this config.
-	ScrapeTimeout time.Duration `yaml:"scrape_timeout,omitempty"`
+	ScrapeTimeout time.Duration `yaml:"scrape_timeout,omitempty" json:"scrape_timeout"`
 
-	ProfilingConfig *ProfilingConfig `yaml:"profiling_config,omitempty"`
-	Targets         []string         `yaml:"targets"`
+	ProfilingConfig *ProfilingConfig `yaml:"profiling_config,omitempty" json:"profiling_config"`
+	Targets         []string         `yaml:"targets" json:"targets"`
+	// Labels is attached to all the profile targets of this scrape config, such as the cluster name and the team.
+	Labels map[string]string `yaml:"labels,omitempty" json:"labels"`
 }
 
 type ProfilingConfig struct {
-	PprofConfig PprofConfig `yaml:"pprof_config,omitempty"`
+	PprofConfig PprofConfig `yaml:"pprof_config,omitempty" json:"pprof_config"`
 }
 
 type PprofConfig map[string]*PprofProfilingConfig
 
 type PprofProfilingConfig struct {
-	Path    string            `yaml:"path,omitempty"`
-	Seconds int               `yaml:"seconds"`
-	Header  map[string]string `yaml:"header,omitempty"`
-	Params  map[string]string `yaml:"params,omitempty"`
+	// Enabled is nil means enabled.
+	Enabled *bool             `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	Path    string            `yaml:"path,omitempty" json:"path"`
+	Seconds int               `yaml:"seconds" json:"seconds"`
+	Header  map[string]string `yaml:"header,omitempty" json:"header,omitempty"`
+	Params  map[string]string `yaml:"params,omitempty" json:"params,omitempty"`
+	// SkipEmpty means don't store the profile which has no samples, such as the block profile of the target
+	// which doesn't enable the block profile rate. The target is still scraped every interval since the rate
+	// is not exposed by the pprof HTTP API, only the empty profile is dropped after it's scraped.
+	SkipEmpty bool `yaml:"skip_empty,omitempty" json:"skip_empty,omitempty"`
+	// IntervalSeconds overrides the scrape interval of this profile kind if it is greater than 0.
+	IntervalSeconds int `yaml:"interval_seconds,omitempty" json:"interval_seconds,omitempty"`
+	// TimeoutSeconds overrides the scrape timeout of this profile kind if it is greater than 0.
+	TimeoutSeconds int `yaml:"timeout_seconds,omitempty" json:"timeout_seconds,omitempty"`
+	// MaxSize is the max size in bytes of the scraped profile, 0 means use the continuous_profiling.max_profile_size.
+	MaxSize int `yaml:"max_size,omitempty" json:"max_size,omitempty"`
+	// Source is the name of the profile source, empty means pull the profile by the pprof HTTP API.
+	// The exec and file sources are only allowed in the scrape_configs, see isLocalSource.
+	Source string `yaml:"source,omitempty" json:"source,omitempty"`
+	// Command is the command of the exec source, the placeholders {output}, {seconds}, {address} and {host}
+	// are replaced before running. The profile is read from {output} if it's used, otherwise from the stdout.
+	Command []string `yaml:"command,omitempty" json:"command,omitempty"`
+	// Dir is the directory of the file source, Pattern is the glob pattern of the profile file names.
+	Dir     string `yaml:"dir,omitempty" json:"dir,omitempty"`
+	Pattern string `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+	// Format declares the format of the profile, such as `perf`, the declared format is stored without
+	// validation. Empty means detect the format and validate the profile. It's also the file extension of
+	// the downloaded profile, so only letters, digits, `_` and `-` are allowed.
+	Format string `yaml:"format,omitempty" json:"format,omitempty"`
+}
+
+func (c *PprofProfilingConfig) IsEnabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+// merge returns a new PprofProfilingConfig which the zero value fields are inherited from base.
+func (c *PprofProfilingConfig) merge(base *PprofProfilingConfig) *PprofProfilingConfig {
+	merged := *base
+	if c.Enabled != nil {
+		merged.Enabled = c.Enabled
+	}
+	if c.Path != "" {
+		merged.Path = c.Path
+	}
+	if c.Seconds > 0 {
+		merged.Seconds = c.Seconds
+	}
+	if c.Header != nil {
+		merged.Header = c.Header
+	}
+	if c.Params != nil {
+		merged.Params = c.Params
+	}
+	if c.SkipEmpty {
+		merged.SkipEmpty = true
+	}
+	if c.IntervalSeconds > 0 {
+		merged.IntervalSeconds = c.IntervalSeconds
+	}
+	if c.TimeoutSeconds > 0 {
+		merged.TimeoutSeconds = c.TimeoutSeconds
+	}
+	if c.MaxSize > 0 {
+		merged.MaxSize = c.MaxSize
+	}
+	if c.Source != "" {
+		merged.Source = c.Source
+	}
+	if c.Command != nil {
+		merged.Command = c.Command
+	}
+	if c.Dir != "" {
+		merged.Dir = c.Dir
+	}
+	if c.Pattern != "" {
+		merged.Pattern = c.Pattern
+	}
+	if c.Format != "" {
+		merged.Format = c.Format
+	}
+	return &merged
+}
+
+// GetPath returns the pprof http path, the default path is /debug/pprof/{kind}.
+func (c *PprofProfilingConfig) GetPath(kind string) string {
+	if c.Path != "" {
+		return c.Path
+	}
+	return fmt.Sprintf("/debug/pprof/%v", kind)
+}
+
+// defaultPprofConfig returns the built-in pprof config of the component.
+func defaultPprofConfig(component string, profileSeconds int) PprofConfig {
+	switch component {
+	case "tikv", "tiflash":
+		return nonGoAppPprofConfig(profileSeconds)
+	default:
+		return goAppPprofConfig(profileSeconds)
+	}
+}
+
+func goAppPprofConfig(profileSeconds int) PprofConfig {
+	disabled := false
+	return PprofConfig{
+		"allocs": &PprofProfilingConfig{
+			Path: "/debug/pprof/allocs",
+		},
+		// block is empty unless the target calls runtime.SetBlockProfileRate, the empty ones are not stored.
+		"block": &PprofProfilingConfig{
+			Path:      "/debug/pprof/block",
+			SkipEmpty: true,
+		},
+		"goroutine": &PprofProfilingConfig{
+			Path:   "/debug/pprof/goroutine",
+			Params: map[string]string{"debug": "2"},
+		},
+		"heap": &PprofProfilingConfig{
+			Path: "/debug/pprof/heap",
+		},
+		"mutex": &PprofProfilingConfig{
+			Path: "/debug/pprof/mutex",
+		},
+		"profile": &PprofProfilingConfig{
+			Path:    "/debug/pprof/profile",
+			Seconds: profileSeconds,
+		},
+		"threadcreate": &PprofProfilingConfig{
+			Path: "/debug/pprof/threadcreate",
+		},
+		// trace is the go execution trace, it is disabled by default since it is expensive.
+		"trace": &PprofProfilingConfig{
+			Enabled:         &disabled,
+			Path:            "/debug/pprof/trace",
+			Seconds:         DefTraceSeconds,
+			IntervalSeconds: DefTraceIntervalSeconds,
+			MaxSize:         DefTraceMaxSize,
+		},
+	}
+}
+
+func nonGoAppPprofConfig(profileSeconds int) PprofConfig {
+	return PprofConfig{
+		// heap is the jemalloc heap profile, the heap profiling is activated during the seconds.
+		"heap": &PprofProfilingConfig{
+			Path:    "/debug/pprof/heap",
+			Seconds: profileSeconds,
+		},
+		"profile": &PprofProfilingConfig{
+			Path:    "/debug/pprof/profile",
+			Seconds: profileSeconds,
+			Header:  map[string]string{"Content-Type": "application/protobuf"},
+		},
+	}
 }
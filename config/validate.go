@@ -0,0 +1,328 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationError describes an invalid config field.
+type ValidationError struct {
+	// Field is the YAML path of the invalid field, such as `scrape_configs[0].scrape_timeout`.
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// ValidationErrors contains all the problems found in a config.
+type ValidationErrors []*ValidationError
+
+func (es ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(es))
+	for _, e := range es {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) addError(field, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Validate checks the config and returns a ValidationErrors which contains all the invalid fields.
+func (c *Config) Validate() error {
+	v := &validator{}
+	if c.Port == 0 || c.Port > 65535 {
+		v.addError("port", "port(%v) should be in range [1, 65535]", c.Port)
+	}
+	if c.StorePath == "" {
+		v.addError("store_path", "store_path should not be empty")
+	}
+	if c.PDAddr != "" && !isValidAddress(c.PDAddr) {
+		v.addError("pd_address", "pd_address(%v) is invalid, the format should be host:port", c.PDAddr)
+	}
+	c.Security.validate(v)
+	c.ContinueProfiling.validate(v)
+	c.Ingest.validate(v)
+	c.Discovery.validate(v)
+	for i, job := range c.ScrapeConfigs {
+		job.validate(v, fmt.Sprintf("scrape_configs[%v]", i), c.ContinueProfiling)
+	}
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+func (s *Security) validate(v *validator) {
+	files := []struct {
+		field string
+		path  string
+	}{
+		{"security.ssl_ca", s.SSLCA},
+		{"security.ssl_cert", s.SSLCert},
+		{"security.ssl_key", s.SSLKey},
+	}
+	specified := 0
+	for _, f := range files {
+		if f.path == "" {
+			continue
+		}
+		specified++
+		if _, err := os.Stat(f.path); err != nil {
+			v.addError(f.field, "%v(%v) is not accessible: %v", f.field, f.path, err)
+		}
+	}
+	if specified > 0 && specified < len(files) {
+		v.addError("security", "security.ssl_ca, security.ssl_cert and security.ssl_key should be specified together")
+	}
+}
+
+func (c *ContinueProfilingConfig) validate(v *validator) {
+	path := "continuous_profiling"
+	validateSchedule(v, path, c.ProfileSeconds, c.IntervalSeconds, c.TimeoutSeconds)
+	if c.DataRetentionSeconds <= c.IntervalSeconds {
+		v.addError(path+".data_retention_seconds",
+			"%v.data_retention_seconds(%v) should be greater than the %v.interval_seconds(%v)",
+			path, c.DataRetentionSeconds, path, c.IntervalSeconds)
+	}
+
+	if c.MaxProfileSize <= 0 {
+		v.addError(path+".max_profile_size", "%v.max_profile_size(%v) should be greater than 0", path, c.MaxProfileSize)
+	}
+
+	if c.MaxConcurrentScrapes < 0 {
+		v.addError(path+".max_concurrent_scrapes", "%v.max_concurrent_scrapes(%v) should not be negative", path, c.MaxConcurrentScrapes)
+	}
+	if c.MaxHostHeavyScrapes < 0 {
+		v.addError(path+".max_host_heavy_scrapes", "%v.max_host_heavy_scrapes(%v) should not be negative", path, c.MaxHostHeavyScrapes)
+	}
+	if c.ScrapeWorkers <= 0 {
+		v.addError(path+".scrape_workers", "%v.scrape_workers(%v) should be greater than 0", path, c.ScrapeWorkers)
+	}
+
+	kinds := make([]string, 0, len(c.Kinds))
+	for kind := range c.Kinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		field := fmt.Sprintf("%v.kinds.%v", path, kind)
+		schedule := c.Kinds[kind]
+		if schedule.IntervalSeconds < 0 {
+			v.addError(field+".interval_seconds", "%v.interval_seconds(%v) should not be negative", field, schedule.IntervalSeconds)
+		}
+		if schedule.TimeoutSeconds < 0 {
+			v.addError(field+".timeout_seconds", "%v.timeout_seconds(%v) should not be negative", field, schedule.TimeoutSeconds)
+		}
+		if schedule.TimeoutSeconds <= 0 {
+			continue
+		}
+		// check the effective seconds of each component, which may be overridden by the component.
+		for _, component := range c.componentNames() {
+			pc := c.GetPprofConfig(component)[kind]
+			if pc != nil && pc.TimeoutSeconds == schedule.TimeoutSeconds && pc.Seconds >= schedule.TimeoutSeconds {
+				v.addError(field+".timeout_seconds", "%v.timeout_seconds(%v) should be greater than the %v seconds(%v) of %v",
+					field, schedule.TimeoutSeconds, kind, pc.Seconds, component)
+				break
+			}
+		}
+	}
+
+	components := make([]string, 0, len(c.Components))
+	for name := range c.Components {
+		components = append(components, name)
+	}
+	sort.Strings(components)
+	for _, name := range components {
+		override := c.Components[name]
+		componentPath := fmt.Sprintf("%v.components.%v", path, name)
+		cfg := c.ForComponent(name)
+		if override.ProfileSeconds != 0 || override.IntervalSeconds != 0 || override.TimeoutSeconds != 0 {
+			validateSchedule(v, componentPath, cfg.ProfileSeconds, cfg.IntervalSeconds, cfg.TimeoutSeconds)
+		}
+		if len(override.PprofConfig) == 0 {
+			continue
+		}
+		pprofConfig := c.GetPprofConfig(name)
+		kinds := make([]string, 0, len(override.PprofConfig))
+		for kind := range override.PprofConfig {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			field := fmt.Sprintf("%v.pprof_config.%v", componentPath, kind)
+			pc := pprofConfig[kind]
+			if pc == nil {
+				v.addError(field, "%v should not be empty", field)
+				continue
+			}
+			if pc.TimeoutSeconds > 0 {
+				if pc.Seconds < 0 || pc.Seconds >= pc.TimeoutSeconds {
+					v.addError(field+".seconds", "%v.seconds(%v) should be in range [0, %v.timeout_seconds(%v))",
+						field, pc.Seconds, field, pc.TimeoutSeconds)
+				}
+			} else if pc.Seconds < 0 || pc.Seconds >= cfg.TimeoutSeconds {
+				v.addError(field+".seconds", "%v.seconds(%v) should be in range [0, %v.timeout_seconds(%v))",
+					field, pc.Seconds, componentPath, cfg.TimeoutSeconds)
+			}
+			validatePprofLimits(v, field, pc)
+			// continuous_profiling can be modified by the HTTP API, so the sources which run on the conprof
+			// host are only allowed in the scrape_configs of the config file.
+			if isLocalSource(pc.Source) {
+				v.addError(field+".source", "%v.source(%v) is only allowed in the scrape_configs of the config file",
+					field, pc.Source)
+			}
+		}
+	}
+}
+
+func (c *IngestConfig) validate(v *validator) {
+	if !c.Enable {
+		return
+	}
+	if len(c.Tokens) == 0 {
+		v.addError("ingest.tokens", "ingest.tokens should not be empty when the ingest is enabled")
+	}
+	for i, token := range c.Tokens {
+		if token == "" {
+			v.addError(fmt.Sprintf("ingest.tokens[%v]", i), "ingest.tokens[%v] should not be empty", i)
+		}
+	}
+	if c.MaxSize <= 0 {
+		v.addError("ingest.max_size", "ingest.max_size(%v) should be greater than 0", c.MaxSize)
+	}
+}
+
+func validateSchedule(v *validator, path string, profileSeconds, intervalSeconds, timeoutSeconds int) {
+	if profileSeconds <= 0 {
+		v.addError(path+".profile_seconds",
+			"%v.profile_seconds(%v) should be greater than 0", path, profileSeconds)
+	}
+	if profileSeconds >= timeoutSeconds {
+		v.addError(path+".profile_seconds",
+			"%v.profile_seconds(%v) should less than the %v.timeout_seconds(%v)",
+			path, profileSeconds, path, timeoutSeconds)
+	}
+	if intervalSeconds <= 0 {
+		v.addError(path+".interval_seconds",
+			"%v.interval_seconds(%v) should be greater than 0", path, intervalSeconds)
+	}
+}
+
+func (c *ScrapeConfig) validate(v *validator, path string, profilingCfg ContinueProfilingConfig) {
+	if c.ComponentName == "" {
+		v.addError(path+".component_name", "%v.component_name should not be empty", path)
+	}
+	if len(c.Targets) == 0 {
+		v.addError(path+".targets", "job %v, targets should not be empty", c.ComponentName)
+	}
+	for i, target := range c.Targets {
+		if !isValidAddress(target) {
+			v.addError(fmt.Sprintf("%v.targets[%v]", path, i),
+				"job %v, target(%v) is invalid, the format should be host:port", c.ComponentName, target)
+		}
+	}
+	if c.ScrapeInterval < 0 {
+		v.addError(path+".scrape_interval", "job %v, scrape_interval(%v) should not be negative", c.ComponentName, c.ScrapeInterval)
+	}
+	if c.ScrapeTimeout < 0 {
+		v.addError(path+".scrape_timeout", "job %v, scrape_timeout(%v) should not be negative", c.ComponentName, c.ScrapeTimeout)
+	}
+	if c.ScrapeInterval > 0 && c.ScrapeTimeout > c.ScrapeInterval {
+		v.addError(path+".scrape_timeout", "job %v, scrape_timeout(%v) should not be greater than the scrape_interval(%v)",
+			c.ComponentName, c.ScrapeTimeout, c.ScrapeInterval)
+	}
+	if _, ok := c.Labels[""]; ok {
+		v.addError(path+".labels", "job %v, the label name should not be empty", c.ComponentName)
+	}
+	if c.ProfilingConfig == nil {
+		return
+	}
+	jobTimeout := c.ScrapeTimeout
+	if jobTimeout <= 0 {
+		jobTimeout = time.Duration(profilingCfg.TimeoutSeconds) * time.Second
+	}
+	kinds := make([]string, 0, len(c.ProfilingConfig.PprofConfig))
+	for kind := range c.ProfilingConfig.PprofConfig {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		field := fmt.Sprintf("%v.profiling_config.pprof_config.%v", path, kind)
+		pc := c.ProfilingConfig.PprofConfig[kind]
+		if pc == nil {
+			v.addError(field, "job %v, %v should not be empty", c.ComponentName, kind)
+			continue
+		}
+		if pc.Seconds < 0 {
+			v.addError(field+".seconds", "job %v, %v.seconds(%v) should not be negative", c.ComponentName, kind, pc.Seconds)
+		}
+		timeout := jobTimeout
+		if pc.TimeoutSeconds > 0 {
+			timeout = time.Duration(pc.TimeoutSeconds) * time.Second
+		}
+		if time.Duration(pc.Seconds)*time.Second >= timeout {
+			v.addError(field+".seconds", "job %v, %v.seconds(%v) should less than the scrapscrape_timeout(%v)",
+				c.ComponentName, kind, pc.Seconds, timeout)
+		}
+		validatePprofLimits(v, field, pc)
+	}
+}
+
+func validatePprofLimits(v *validator, field string, pc *PprofProfilingConfig) {
+	if pc.IntervalSeconds < 0 {
+		v.addError(field+".interval_seconds", "%v.interval_seconds(%v) should not be negative", field, pc.IntervalSeconds)
+	}
+	if pc.TimeoutSeconds < 0 {
+		v.addError(field+".timeout_seconds", "%v.timeout_seconds(%v) should not be negative", field, pc.TimeoutSeconds)
+	}
+	if pc.MaxSize < 0 {
+		v.addError(field+".max_size", "%v.max_size(%v) should not be negative", field, pc.MaxSize)
+	}
+	if pc.Format != "" && !formatRegexp.MatchString(pc.Format) {
+		v.addError(field+".format", "%v.format(%v) should only contain letters, digits, `_` and `-`", field, pc.Format)
+	}
+	switch pc.Source {
+	case "exec":
+		if len(pc.Command) == 0 {
+			v.addError(field+".command", "%v.command should not be empty when the source is exec", field)
+		}
+	case "file":
+		if pc.Dir == "" {
+			v.addError(field+".dir", "%v.dir should not be empty when the source is file", field)
+		}
+	}
+}
+
+// formatRegexp matches the declared profile format, which is used as the file extension of the downloaded profile.
+var formatRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// isLocalSource returns true if the profile source runs a command or reads the files on the conprof host.
+func isLocalSource(source string) bool {
+	return source == "exec" || source == "file"
+}
+
+func isValidAddress(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	_, err = strconv.ParseUint(port, 10, 16)
+	return err == nil
+}
@@ -2,204 +2,229 @@ package discovery
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
+	"io"
 	"sync"
-	"time"
 
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/metrics"
 	"github.com/crazycs520/continuous-profile/util"
 	"github.com/pingcap/log"
-	dashboard_config "github.com/pingcap/tidb-dashboard/pkg/config"
-	"github.com/pingcap/tidb-dashboard/pkg/httpc"
-	"github.com/pingcap/tidb-dashboard/pkg/pd"
-	"github.com/pingcap/tidb-dashboard/pkg/utils/topology"
-	"go.etcd.io/etcd/clientv3"
-	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
 const (
-	discoverInterval = time.Second * 30
 	ComponentTiDB    = "tidb"
 	ComponentTiKV    = "tikv"
 	ComponentTiFlash = "tiflash"
 	ComponentPD      = "pd"
 )
 
-type TopologyDiscoverer struct {
-	sync.Mutex
-	PDClient   *pd.Client
-	EtcdClient *clientv3.Client
-	subscriber []chan []Component
-	closed     chan struct{}
-}
+// The name of the discovery providers, which is recorded in the Source of the discovered components.
+const (
+	SourcePD     = "pd"
+	SourceStatic = "static"
+	SourceFile   = "file"
+	// SourceScrapeConfig is the source of the components declared in the scrape_configs.
+	SourceScrapeConfig = "scrape_config"
+)
 
 type Component struct {
 	Name       string `json:"name"`
 	IP         string `json:"ip"`
 	Port       uint   `json:"port"`
 	StatusPort uint   `json:"status_port"`
+	// Labels is the labels of the component, such as the labels of the TiKV store, which are attached to
+	// all the profile targets of the component.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Version, GitHash and StartTimestamp are the build info reported by the component, they are empty if unknown.
+	// They are only known for the components in the PD topology, the scrape manager also fetches them from the
+	// status endpoints of TiDB and PD.
+	Version        string `json:"version,omitempty"`
+	GitHash        string `json:"git_hash,omitempty"`
+	StartTimestamp int64  `json:"start_timestamp,omitempty"`
+	// Instance is the stable identity of the component, such as the TiKV store ID, which doesn't change with
+	// the address. It's empty if unknown.
+	Instance string `json:"instance,omitempty"`
+	// Source is the name of the discovery provider which found the component.
+	Source string `json:"source,omitempty"`
 }
 
-type Subscriber = chan []Component
+// ComponentKey identifies a component, it's comparable and can be used as the map key. The component is
+// identified by its status address, which is the address of the profile targets. The port is not included
+// since it's unknown to the providers other than PD, such as a TiKV is 20160 in the PD topology but 20180
+// in the scrape_configs.
+type ComponentKey struct {
+	Name       string
+	IP         string
+	StatusPort uint
+}
 
-func NewTopologyDiscoverer(pdAddr string, tlsConfig *tls.Config) (*TopologyDiscoverer, error) {
-	cfg := buildDashboardConfig(pdAddr, tlsConfig)
-	lc := &mockLifecycle{}
-	httpCli := httpc.NewHTTPClient(lc, cfg)
-	pdCli := pd.NewPDClient(lc, httpCli, cfg)
-	etcdCli, err := pd.NewEtcdClient(lc, cfg)
-	if err != nil {
-		return nil, err
+// Key returns the key of the component.
+func (c Component) Key() ComponentKey {
+	return ComponentKey{
+		Name:       c.Name,
+		IP:         c.IP,
+		StatusPort: c.StatusPort,
 	}
-	d := &TopologyDiscoverer{
-		PDClient:   pdCli,
-		EtcdClient: etcdCli,
-		closed:     make(chan struct{}),
-	}
-	return d, nil
 }
 
-func (d *TopologyDiscoverer) Subscribe() chan []Component {
-	ch := make(chan []Component)
-	d.Lock()
-	d.subscriber = append(d.subscriber, ch)
-	d.Unlock()
-	return ch
-}
+type Subscriber = chan []Component
 
-func (d *TopologyDiscoverer) Start() {
-	go util.GoWithRecovery(d.loadTopologyLoop, nil)
+// Discoverer is a discovery provider, such as the TiDB cluster topology, a static list or a file.
+type Discoverer interface {
+	// Name returns the name of the provider, it's recorded in the Source of the discovered components.
+	Name() string
+	// Run sends the full list of the discovered components into ch whenever it's loaded, until ctx is done.
+	Run(ctx context.Context, ch chan<- []Component)
 }
 
-func (d *TopologyDiscoverer) Close() error {
-	close(d.closed)
-	return d.EtcdClient.Close()
+// MultiDiscoverer composes the discovery providers, it sends the union of the latest components of all the
+// providers to the subscribers. The component found by multiple providers at the same status address is taken
+// from the first one.
+type MultiDiscoverer struct {
+	sync.Mutex
+	discoverers []Discoverer
+	latest      [][]Component
+	subscriber  []Subscriber
+	cancel      context.CancelFunc
+	// duplicates is the components found by multiple providers in the last merge, the value is the source of
+	// the duplicate one. It's used to log the duplicate component only once.
+	duplicates map[ComponentKey]string
 }
 
-func (d *TopologyDiscoverer) loadTopologyLoop() {
-	d.loadTopology()
-	ticker := time.NewTicker(discoverInterval)
-	for {
-		select {
-		case <-d.closed:
-			return
-		case <-ticker.C:
-			d.loadTopology()
-		}
+func NewMultiDiscoverer(discoverers ...Discoverer) *MultiDiscoverer {
+	return &MultiDiscoverer{
+		discoverers: discoverers,
+		latest:      make([][]Component, len(discoverers)),
 	}
 }
 
-func (d *TopologyDiscoverer) loadTopology() {
-	ctx, cancel := context.WithTimeout(context.Background(), discoverInterval)
-	defer cancel()
-	components, err := d.getAllScrapeTargets(ctx)
-	if err != nil {
-		log.Error("load topology failed", zap.Error(err))
-		return
-	}
-	d.notifySubscriber(components)
+// Subscribe returns the channel which receives the components, only the latest components are kept if the
+// subscriber is slow.
+func (d *MultiDiscoverer) Subscribe() Subscriber {
+	ch := make(Subscriber, 1)
+	d.Lock()
+	d.subscriber = append(d.subscriber, ch)
+	d.Unlock()
+	return ch
 }
 
-func (d *TopologyDiscoverer) notifySubscriber(components []Component) {
-	for _, ch := range d.subscriber {
-		select {
-		case ch <- components:
-		default:
-		}
+func (d *MultiDiscoverer) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	for i, discoverer := range d.discoverers {
+		i, discoverer := i, discoverer
+		ch := make(chan []Component)
+		go util.GoWithRecovery(func() {
+			discoverer.Run(ctx, ch)
+		}, nil)
+		go util.GoWithRecovery(func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case components := <-ch:
+					d.update(i, components)
+				}
+			}
+		}, nil)
 	}
 }
 
-func (d *TopologyDiscoverer) getAllScrapeTargets(ctx context.Context) ([]Component, error) {
-	fns := []func(context.Context) ([]Component, error){
-		d.getTiDBComponents,
-		d.getPDComponents,
-		d.getStoreComponents,
+// Close stops all the providers and closes the ones which need to be closed.
+func (d *MultiDiscoverer) Close() error {
+	if d.cancel != nil {
+		d.cancel()
 	}
-	components := make([]Component, 0, 8)
-	for _, fn := range fns {
-		nodes, err := fn(ctx)
-		if err != nil {
-			return nil, err
+	var err error
+	for _, discoverer := range d.discoverers {
+		if closer, ok := discoverer.(io.Closer); ok {
+			if closeErr := closer.Close(); closeErr != nil {
+				log.Warn("close discoverer failed", zap.String("source", discoverer.Name()), zap.Error(closeErr))
+				err = closeErr
+			}
 		}
-		components = append(components, nodes...)
 	}
-	return components, nil
+	return err
 }
 
-func (d *TopologyDiscoverer) getTiDBComponents(ctx context.Context) ([]Component, error) {
-	instances, err := topology.FetchTiDBTopology(ctx, d.EtcdClient)
-	if err != nil {
-		return nil, err
-	}
-	components := make([]Component, 0, len(instances))
-	for _, instance := range instances {
-		if instance.Status != topology.ComponentStatusUp {
-			continue
-		}
-		components = append(components, Component{
-			Name:       ComponentTiDB,
-			IP:         instance.IP,
-			Port:       instance.Port,
-			StatusPort: instance.StatusPort,
-		})
-	}
-	return components, nil
+func (d *MultiDiscoverer) update(idx int, components []Component) {
+	d.Lock()
+	defer d.Unlock()
+	d.latest[idx] = components
+	d.notifySubscriber(d.merge())
 }
 
-func (d *TopologyDiscoverer) getPDComponents(ctx context.Context) ([]Component, error) {
-	instances, err := topology.FetchPDTopology(d.PDClient)
-	if err != nil {
-		return nil, err
-	}
-	components := make([]Component, 0, len(instances))
-	for _, instance := range instances {
-		if instance.Status != topology.ComponentStatusUp {
-			continue
+func (d *MultiDiscoverer) merge() []Component {
+	seen := make(map[ComponentKey]string)
+	duplicates := make(map[ComponentKey]string)
+	components := make([]Component, 0, 8)
+	for i, list := range d.latest {
+		source := d.discoverers[i].Name()
+		for _, comp := range list {
+			key := comp.Key()
+			if winner, ok := seen[key]; ok {
+				if _, logged := d.duplicates[key]; !logged && winner != source {
+					log.Info("component is found by multiple discovery providers, the first one is used",
+						zap.String("component", comp.Name),
+						zap.String("address", fmt.Sprintf("%v:%v", comp.IP, comp.StatusPort)),
+						zap.String("source", winner),
+						zap.String("ignored-source", source))
+				}
+				duplicates[key] = source
+				continue
+			}
+			seen[key] = source
+			comp.Source = source
+			components = append(components, comp)
 		}
-		components = append(components, Component{
-			Name:       ComponentPD,
-			IP:         instance.IP,
-			Port:       instance.Port,
-			StatusPort: instance.Port,
-		})
 	}
-	return components, nil
+	d.duplicates = duplicates
+	return components
 }
 
-func (d *TopologyDiscoverer) getStoreComponents(ctx context.Context) ([]Component, error) {
-	tikvInstances, tiflashInstances, err := topology.FetchStoreTopology(d.PDClient)
-	if err != nil {
-		return nil, err
+func (d *MultiDiscoverer) notifySubscriber(components []Component) {
+	counts := make(map[string]int)
+	for _, comp := range components {
+		counts[comp.Name]++
 	}
-	components := make([]Component, 0, len(tikvInstances)+len(tiflashInstances))
-	getComponents := func(instances []topology.StoreInfo, name string) {
-		for _, instance := range instances {
-			if instance.Status != topology.ComponentStatusUp {
-				continue
+	metrics.DiscoveredComponentsGauge.Reset()
+	for name, count := range counts {
+		metrics.DiscoveredComponentsGauge.WithLabelValues(name).Set(float64(count))
+	}
+	for _, ch := range d.subscriber {
+		// replace the components which are not received yet, the subscriber only needs the latest ones.
+		for sent := false; !sent; {
+			select {
+			case ch <- components:
+				sent = true
+			default:
+				select {
+				case <-ch:
+				default:
+				}
 			}
-			components = append(components, Component{
-				Name:       name,
-				IP:         instance.IP,
-				Port:       instance.Port,
-				StatusPort: instance.StatusPort,
-			})
 		}
 	}
-	getComponents(tikvInstances, ComponentTiKV)
-	getComponents(tiflashInstances, ComponentTiFlash)
-	return components, nil
 }
 
-func buildDashboardConfig(pdAddr string, tlsConfig *tls.Config) *dashboard_config.Config {
-	return &dashboard_config.Config{
-		PDEndPoint:       fmt.Sprintf("http://%v", pdAddr),
-		ClusterTLSConfig: tlsConfig,
+// NewDiscoverers returns the discovery providers of the config, the TiDB cluster topology is discovered only if
+// the pd_address is specified.
+func NewDiscoverers(cfg *config.Config) ([]Discoverer, error) {
+	var discoverers []Discoverer
+	if cfg.PDAddr != "" {
+		d, err := NewTopologyDiscoverer(cfg.PDAddr, cfg.Security.GetTLSConfig())
+		if err != nil {
+			return nil, err
+		}
+		discoverers = append(discoverers, d)
 	}
-}
-
-type mockLifecycle struct{}
-
-func (_ *mockLifecycle) Append(fx.Hook) {
-	return
+	if len(cfg.Discovery.StaticConfigs) > 0 {
+		discoverers = append(discoverers, NewStaticDiscoverer(cfg.Discovery.StaticConfigs))
+	}
+	for _, fc := range cfg.Discovery.FileConfigs {
+		discoverers = append(discoverers, NewFileDiscoverer(fc))
+	}
+	return discoverers, nil
 }
@@ -0,0 +1,161 @@
+package discovery
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type mockDiscoverer struct {
+	name string
+	ch   chan []Component
+}
+
+func (d *mockDiscoverer) Name() string {
+	return d.name
+}
+
+func (d *mockDiscoverer) Run(ctx context.Context, ch chan<- []Component) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case components := <-d.ch:
+			ch <- components
+		}
+	}
+}
+
+func TestMultiDiscoverer(t *testing.T) {
+	d1 := &mockDiscoverer{name: "d1", ch: make(chan []Component)}
+	d2 := &mockDiscoverer{name: "d2", ch: make(chan []Component)}
+	d := NewMultiDiscoverer(d1, d2)
+	sub := d.Subscribe()
+	d.Start()
+	defer d.Close()
+
+	tidb := Component{Name: ComponentTiDB, IP: "10.0.1.1", Port: 4000, StatusPort: 10080}
+	tikv := Component{Name: ComponentTiKV, IP: "10.0.1.2", Port: 20160, StatusPort: 20180}
+	d1.ch <- []Component{tidb}
+	components := receive(t, sub)
+	require.Len(t, components, 1)
+	require.Equal(t, "d1", components[0].Source)
+
+	// the component found by both providers is taken from the first one.
+	d2.ch <- []Component{tidb, tikv}
+	components = receive(t, sub)
+	require.Len(t, components, 2)
+	require.Equal(t, "d1", components[0].Source)
+	require.Equal(t, tikv.Key(), components[1].Key())
+	require.Equal(t, "d2", components[1].Source)
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.DiscoveredComponentsGauge.WithLabelValues(ComponentTiDB)))
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.DiscoveredComponentsGauge.WithLabelValues(ComponentTiKV)))
+
+	// the component is identified by the status address, the port reported by the providers may be different.
+	staticTiKV := Component{Name: ComponentTiKV, IP: "10.0.1.2", Port: 20180, StatusPort: 20180}
+	d1.ch <- []Component{tidb, tikv}
+	receive(t, sub)
+	d2.ch <- []Component{tidb, staticTiKV}
+	components = receive(t, sub)
+	require.Len(t, components, 2)
+	require.Equal(t, uint(20160), components[1].Port)
+	require.Equal(t, "d1", components[1].Source)
+
+	d1.ch <- nil
+	components = receive(t, sub)
+	require.Len(t, components, 2)
+	for _, comp := range components {
+		require.Equal(t, "d2", comp.Source)
+	}
+}
+
+func TestStaticDiscoverer(t *testing.T) {
+	d := NewStaticDiscoverer([]*config.StaticDiscoveryConfig{
+		{ComponentName: "app", Targets: []string{"10.0.1.1:6060", "invalid"}, Labels: map[string]string{"team": "a"}},
+	})
+	ch := make(chan []Component, 1)
+	d.Run(context.Background(), ch)
+	components := <-ch
+	require.Len(t, components, 1)
+	require.Equal(t, Component{
+		Name:       "app",
+		IP:         "10.0.1.1",
+		Port:       6060,
+		StatusPort: 6060,
+		Labels:     map[string]string{"team": "a"},
+	}, components[0])
+}
+
+func TestFileDiscoverer(t *testing.T) {
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "tidb.json")
+	yamlFile := filepath.Join(dir, "app.yaml")
+	writeFile := func(path, data string) {
+		require.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+		// make sure the modification time is changed.
+		modTime := time.Now().Add(time.Duration(len(data)) * time.Second)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	d := NewFileDiscoverer(&config.FileDiscoveryConfig{Path: filepath.Join(dir, "*"), ComponentName: "app"})
+	require.Equal(t, config.DefFileDiscoveryRefreshInterval, d.interval)
+	require.False(t, d.refresh())
+	require.Len(t, d.components(), 0)
+
+	writeFile(jsonFile, `[{"targets": ["10.0.1.1:10080", "10.0.1.2:10080"], "labels": {"__component__": "tidb", "team": "sql"}}]`)
+	require.True(t, d.refresh())
+	components := d.components()
+	require.Len(t, components, 2)
+	require.Equal(t, Component{
+		Name:       ComponentTiDB,
+		IP:         "10.0.1.2",
+		Port:       10080,
+		StatusPort: 10080,
+		Labels:     map[string]string{"team": "sql"},
+	}, components[1])
+	require.False(t, d.refresh())
+
+	// the component name of the config is used if the target group doesn't specify it.
+	writeFile(yamlFile, "- targets: ['10.0.1.3:6060']\n  labels:\n    env: prod\n")
+	require.True(t, d.refresh())
+	components = d.components()
+	require.Len(t, components, 3)
+	require.Equal(t, "app", components[0].Name)
+	require.Equal(t, "prod", components[0].Labels["env"])
+
+	// the last good components are kept if the file is malformed.
+	writeFile(jsonFile, `[{"targets": ["10.0.1.1"]}]`)
+	require.False(t, d.refresh())
+	writeFile(yamlFile, "- targets: [")
+	require.False(t, d.refresh())
+	require.Equal(t, components, d.components())
+
+	writeFile(jsonFile, `[{"component_name": "tidb", "targets": ["10.0.1.1:10080"]}]`)
+	require.True(t, d.refresh())
+	require.Len(t, d.components(), 2)
+
+	require.NoError(t, os.Remove(yamlFile))
+	require.True(t, d.refresh())
+	components = d.components()
+	require.Len(t, components, 1)
+	require.Equal(t, "10.0.1.1", components[0].IP)
+	require.Nil(t, components[0].Labels)
+}
+
+func receive(t *testing.T, sub Subscriber) []Component {
+	select {
+	case components := <-sub:
+		return components
+	case <-time.After(time.Second):
+		require.FailNow(t, "receive components timeout")
+		return nil
+	}
+}
@@ -0,0 +1,233 @@
+package discovery
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/metrics"
+	"github.com/pingcap/log"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+// componentLabel is the label to specify the component name of a target group, it's not recorded in the labels.
+const componentLabel = "__component__"
+
+// FileDiscoverer discovers the components from the target group files in the Prometheus file_sd format, the
+// format of the file is decided by the extension, it's JSON for .json and YAML for .yml and .yaml, such as:
+//
+//	[{"targets": ["10.0.1.1:10080"], "labels": {"__component__": "tidb", "team": "sql"}}]
+//
+// The files are checked every refresh interval, and only reloaded if the modification time or the size is
+// changed. The last good components of a malformed file are kept until the file is fixed or removed.
+type FileDiscoverer struct {
+	pattern   string
+	component string
+	interval  time.Duration
+	files     map[string]*fileState
+}
+
+type fileState struct {
+	modTime    time.Time
+	size       int64
+	hash       [sha256.Size]byte
+	components []Component
+}
+
+// targetGroup is a group of the targets in the file.
+type targetGroup struct {
+	// ComponentName takes precedence over the __component__ label.
+	ComponentName string            `json:"component_name" yaml:"component_name"`
+	Targets       []string          `json:"targets" yaml:"targets"`
+	Labels        map[string]string `json:"labels" yaml:"labels"`
+}
+
+func NewFileDiscoverer(cfg *config.FileDiscoveryConfig) *FileDiscoverer {
+	interval := cfg.RefreshInterval
+	if interval <= 0 {
+		interval = config.DefFileDiscoveryRefreshInterval
+	}
+	return &FileDiscoverer{
+		pattern:   cfg.Path,
+		component: cfg.ComponentName,
+		interval:  interval,
+		files:     make(map[string]*fileState),
+	}
+}
+
+// Name implements the Discoverer interface.
+func (d *FileDiscoverer) Name() string {
+	return SourceFile
+}
+
+// Run implements the Discoverer interface, the components are sent once the files are changed.
+func (d *FileDiscoverer) Run(ctx context.Context, ch chan<- []Component) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for first := true; ; first = false {
+		if d.refresh() || first {
+			select {
+			case ch <- d.components():
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh reloads the changed files, it returns true if the components are changed.
+func (d *FileDiscoverer) refresh() bool {
+	paths, err := filepath.Glob(d.pattern)
+	if err != nil {
+		log.Error("invalid discovery file pattern", zap.String("path", d.pattern), zap.Error(err))
+		return false
+	}
+	changed := false
+	seen := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		seen[path] = struct{}{}
+		if d.refreshFile(path) {
+			changed = true
+		}
+	}
+	for path := range d.files {
+		if _, ok := seen[path]; !ok {
+			log.Info("discovery file is removed", zap.String("path", path))
+			delete(d.files, path)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (d *FileDiscoverer) refreshFile(path string) bool {
+	stat, err := os.Stat(path)
+	if err != nil {
+		d.reportError(path, err)
+		return false
+	}
+	state := d.files[path]
+	if state != nil && state.modTime.Equal(stat.ModTime()) && state.size == stat.Size() {
+		return false
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		d.reportError(path, err)
+		return false
+	}
+	if state == nil {
+		state = &fileState{}
+		d.files[path] = state
+	}
+	state.modTime, state.size = stat.ModTime(), stat.Size()
+	hash := sha256.Sum256(data)
+	if hash == state.hash {
+		return false
+	}
+	state.hash = hash
+
+	components, err := parseTargetGroups(path, data, d.component)
+	if err != nil {
+		// keep the last good components, the malformed content is reported only once since the hash is recorded.
+		d.reportError(path, err)
+		return false
+	}
+	state.components = components
+	log.Info("discovery file is reloaded", zap.String("path", path), zap.Int("components", len(components)))
+	return true
+}
+
+func (d *FileDiscoverer) reportError(path string, err error) {
+	metrics.DiscoveryFileErrorCounter.Inc()
+	log.Error("load discovery file failed, keep the last good targets", zap.String("path", path), zap.Error(err))
+}
+
+// components returns the components of all the files, the duplicate components are removed.
+func (d *FileDiscoverer) components() []Component {
+	paths := make([]string, 0, len(d.files))
+	for path := range d.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	seen := make(map[ComponentKey]struct{})
+	components := make([]Component, 0, 8)
+	for _, path := range paths {
+		for _, comp := range d.files[path].components {
+			if _, ok := seen[comp.Key()]; ok {
+				continue
+			}
+			seen[comp.Key()] = struct{}{}
+			components = append(components, comp)
+		}
+	}
+	return components
+}
+
+func parseTargetGroups(path string, data []byte, defComponent string) ([]Component, error) {
+	var groups []*targetGroup
+	var err error
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.Unmarshal(data, &groups)
+	case ".yml", ".yaml":
+		err = yaml.UnmarshalStrict(data, &groups)
+	default:
+		err = fmt.Errorf("unsupported file extension %v, should be .json, .yml or .yaml", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	components := make([]Component, 0, len(groups))
+	for i, group := range groups {
+		if group == nil {
+			continue
+		}
+		name := group.ComponentName
+		if name == "" {
+			name = group.Labels[componentLabel]
+		}
+		if name == "" {
+			name = defComponent
+		}
+		if name == "" {
+			return nil, fmt.Errorf("the component of target group %v is not specified", i)
+		}
+		var labels map[string]string
+		for k, v := range group.Labels {
+			if k == "" {
+				return nil, fmt.Errorf("the label name of target group %v should not be empty", i)
+			}
+			if k == componentLabel {
+				continue
+			}
+			if labels == nil {
+				labels = make(map[string]string, len(group.Labels))
+			}
+			labels[k] = v
+		}
+		for _, target := range group.Targets {
+			comp, err := NewComponent(name, target, labels)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid target %v of target group %v", target, i)
+			}
+			components = append(components, comp)
+		}
+	}
+	return components, nil
+}
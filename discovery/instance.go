@@ -0,0 +1,38 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// StoreInstance returns the instance identity of the TiKV or TiFlash store. The store ID is kept when the store
+// moves to another address. TiDB has no such identity since its server ID is reassigned on every restart, so the
+// history of TiDB is kept by the address only.
+func StoreInstance(storeID uint64) string {
+	return fmt.Sprintf("store-%v", storeID)
+}
+
+// fetchStoreIDs returns the ID of each TiKV and TiFlash store, the key is the address of the store.
+func (d *TopologyDiscoverer) fetchStoreIDs() (map[string]uint64, error) {
+	data, err := d.PDClient.SendGetRequest("/stores")
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Stores []struct {
+			Store struct {
+				ID      uint64 `json:"id"`
+				Address string `json:"address"`
+			} `json:"store"`
+		} `json:"stores"`
+	}
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]uint64, len(resp.Stores))
+	for _, s := range resp.Stores {
+		ids[s.Store.Address] = s.Store.ID
+	}
+	return ids, nil
+}
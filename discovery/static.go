@@ -0,0 +1,71 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/pingcap/log"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// StaticDiscoverer discovers the static list of the components declared in the config.
+type StaticDiscoverer struct {
+	components []Component
+}
+
+func NewStaticDiscoverer(configs []*config.StaticDiscoveryConfig) *StaticDiscoverer {
+	return &StaticDiscoverer{components: buildStaticComponents(configs)}
+}
+
+// Name implements the Discoverer interface.
+func (d *StaticDiscoverer) Name() string {
+	return SourceStatic
+}
+
+// Run implements the Discoverer interface, the static components are sent only once.
+func (d *StaticDiscoverer) Run(ctx context.Context, ch chan<- []Component) {
+	select {
+	case ch <- d.components:
+	case <-ctx.Done():
+	}
+}
+
+func buildStaticComponents(configs []*config.StaticDiscoveryConfig) []Component {
+	components := make([]Component, 0, len(configs))
+	for _, sc := range configs {
+		for _, target := range sc.Targets {
+			comp, err := NewComponent(sc.ComponentName, target, sc.Labels)
+			if err != nil {
+				log.Error("invalid static target",
+					zap.String("component", sc.ComponentName),
+					zap.String("target", target),
+					zap.Error(err))
+				continue
+			}
+			components = append(components, comp)
+		}
+	}
+	return components
+}
+
+// NewComponent returns the component whose status address is addr, the format of addr is host:port.
+func NewComponent(name, addr string, labels map[string]string) (Component, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return Component{}, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return Component{}, errors.Wrapf(err, "invalid port of %v", addr)
+	}
+	return Component{
+		Name:       name,
+		IP:         host,
+		Port:       uint(port),
+		StatusPort: uint(port),
+		Labels:     labels,
+	}, nil
+}
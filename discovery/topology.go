@@ -0,0 +1,190 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pingcap/log"
+	dashboard_config "github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/httpc"
+	"github.com/pingcap/tidb-dashboard/pkg/pd"
+	"github.com/pingcap/tidb-dashboard/pkg/utils/topology"
+	"go.etcd.io/etcd/clientv3"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// discoverInterval is the interval of the full resync, the changes are usually found by the etcd watch.
+const discoverInterval = time.Second * 30
+
+// TopologyDiscoverer discovers the components of the TiDB cluster from PD and etcd.
+type TopologyDiscoverer struct {
+	PDClient   *pd.Client
+	EtcdClient *clientv3.Client
+}
+
+func NewTopologyDiscoverer(pdAddr string, tlsConfig *tls.Config) (*TopologyDiscoverer, error) {
+	cfg := buildDashboardConfig(pdAddr, tlsConfig)
+	lc := &mockLifecycle{}
+	httpCli := httpc.NewHTTPClient(lc, cfg)
+	pdCli := pd.NewPDClient(lc, httpCli, cfg)
+	etcdCli, err := pd.NewEtcdClient(lc, cfg)
+	if err != nil {
+		return nil, err
+	}
+	d := &TopologyDiscoverer{
+		PDClient:   pdCli,
+		EtcdClient: etcdCli,
+	}
+	return d, nil
+}
+
+// Name implements the Discoverer interface.
+func (d *TopologyDiscoverer) Name() string {
+	return SourcePD
+}
+
+// Run implements the Discoverer interface, it reloads the topology once the etcd watch finds the changes, and
+// resyncs the full topology periodically.
+func (d *TopologyDiscoverer) Run(ctx context.Context, ch chan<- []Component) {
+	reload := make(chan struct{}, 1)
+	d.watchTopology(ctx, reload)
+	runReloadLoop(ctx, reload, discoverInterval, func() {
+		d.loadTopology(ctx, ch)
+	})
+}
+
+// Close closes the etcd client.
+func (d *TopologyDiscoverer) Close() error {
+	return d.EtcdClient.Close()
+}
+
+func (d *TopologyDiscoverer) loadTopology(ctx context.Context, ch chan<- []Component) {
+	ctx, cancel := context.WithTimeout(ctx, discoverInterval)
+	defer cancel()
+	components, err := d.getAllScrapeTargets(ctx)
+	if err != nil {
+		log.Error("load topology failed", zap.Error(err))
+		return
+	}
+	select {
+	case ch <- components:
+	case <-ctx.Done():
+	}
+}
+
+func (d *TopologyDiscoverer) getAllScrapeTargets(ctx context.Context) ([]Component, error) {
+	fns := []func(context.Context) ([]Component, error){
+		d.getTiDBComponents,
+		d.getPDComponents,
+		d.getStoreComponents,
+	}
+	components := make([]Component, 0, 8)
+	for _, fn := range fns {
+		nodes, err := fn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		components = append(components, nodes...)
+	}
+	return components, nil
+}
+
+func (d *TopologyDiscoverer) getTiDBComponents(ctx context.Context) ([]Component, error) {
+	instances, err := topology.FetchTiDBTopology(ctx, d.EtcdClient)
+	if err != nil {
+		return nil, err
+	}
+	components := make([]Component, 0, len(instances))
+	for _, instance := range instances {
+		if instance.Status != topology.ComponentStatusUp {
+			continue
+		}
+		components = append(components, Component{
+			Name:           ComponentTiDB,
+			IP:             instance.IP,
+			Port:           instance.Port,
+			StatusPort:     instance.StatusPort,
+			Version:        instance.Version,
+			GitHash:        instance.GitHash,
+			StartTimestamp: instance.StartTimestamp,
+		})
+	}
+	return components, nil
+}
+
+func (d *TopologyDiscoverer) getPDComponents(ctx context.Context) ([]Component, error) {
+	instances, err := topology.FetchPDTopology(d.PDClient)
+	if err != nil {
+		return nil, err
+	}
+	components := make([]Component, 0, len(instances))
+	for _, instance := range instances {
+		if instance.Status != topology.ComponentStatusUp {
+			continue
+		}
+		components = append(components, Component{
+			Name:           ComponentPD,
+			IP:             instance.IP,
+			Port:           instance.Port,
+			StatusPort:     instance.Port,
+			Version:        instance.Version,
+			GitHash:        instance.GitHash,
+			StartTimestamp: instance.StartTimestamp,
+		})
+	}
+	return components, nil
+}
+
+func (d *TopologyDiscoverer) getStoreComponents(ctx context.Context) ([]Component, error) {
+	tikvInstances, tiflashInstances, err := topology.FetchStoreTopology(d.PDClient)
+	if err != nil {
+		return nil, err
+	}
+	storeIDs, err := d.fetchStoreIDs()
+	if err != nil {
+		log.Warn("fetch store id failed", zap.Error(err))
+	}
+	components := make([]Component, 0, len(tikvInstances)+len(tiflashInstances))
+	getComponents := func(instances []topology.StoreInfo, name string) {
+		for _, instance := range instances {
+			if instance.Status != topology.ComponentStatusUp {
+				continue
+			}
+			comp := Component{
+				Name:           name,
+				IP:             instance.IP,
+				Port:           instance.Port,
+				StatusPort:     instance.StatusPort,
+				Labels:         instance.Labels,
+				Version:        instance.Version,
+				GitHash:        instance.GitHash,
+				StartTimestamp: instance.StartTimestamp,
+			}
+			if id, ok := storeIDs[net.JoinHostPort(instance.IP, strconv.Itoa(int(instance.Port)))]; ok {
+				comp.Instance = StoreInstance(id)
+			}
+			components = append(components, comp)
+		}
+	}
+	getComponents(tikvInstances, ComponentTiKV)
+	getComponents(tiflashInstances, ComponentTiFlash)
+	return components, nil
+}
+
+func buildDashboardConfig(pdAddr string, tlsConfig *tls.Config) *dashboard_config.Config {
+	return &dashboard_config.Config{
+		PDEndPoint:       fmt.Sprintf("http://%v", pdAddr),
+		ClusterTLSConfig: tlsConfig,
+	}
+}
+
+type mockLifecycle struct{}
+
+func (_ *mockLifecycle) Append(fx.Hook) {
+	return
+}
@@ -0,0 +1,163 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/util"
+	"github.com/pingcap/log"
+	"go.etcd.io/etcd/clientv3"
+	"go.uber.org/zap"
+)
+
+const (
+	// tidbTopologyPrefix is the etcd prefix of the TiDB topology, the keys are {addr}/info and {addr}/ttl.
+	tidbTopologyPrefix = "/topology/tidb/"
+	// pdClusterIDKey is the etcd key of the PD cluster ID, the store meta is under /pd/{cluster_id}/raft/s/.
+	pdClusterIDKey = "/pd/cluster_id"
+	// watchDebounce coalesces the burst of changes, such as a rolling restart, into one reload.
+	watchDebounce      = 200 * time.Millisecond
+	watchRetryInterval = 3 * time.Second
+)
+
+// watchTopology watches the changes of the TiDB topology and the PD stores, and sends into reload once the
+// topology should be reloaded.
+func (d *TopologyDiscoverer) watchTopology(ctx context.Context, reload chan<- struct{}) {
+	go util.GoWithRecovery(func() {
+		d.watchPrefix(ctx, tidbTopologyPrefix, isTiDBTopologyChange, reload)
+	}, nil)
+	go util.GoWithRecovery(func() {
+		prefix, err := d.getStoreMetaPrefix(ctx)
+		if err != nil {
+			return
+		}
+		d.watchPrefix(ctx, prefix, isValueChange, reload)
+	}, nil)
+}
+
+// watchPrefix watches the prefix until ctx is done, the watch is recreated if it's broken, such as the
+// revision is compacted. The changes are ignored if filter returns false, the events have the previous
+// key-value if it's not compacted.
+func (d *TopologyDiscoverer) watchPrefix(ctx context.Context, prefix string, filter func(*clientv3.Event) bool, reload chan<- struct{}) {
+	for retry := false; ; retry = true {
+		if retry {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(watchRetryInterval):
+			}
+		}
+		// WithRequireLeader breaks the watch if the etcd member is partitioned from the leader.
+		watchCtx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+		wch := d.EtcdClient.Watch(watchCtx, prefix, clientv3.WithPrefix(), clientv3.WithPrevKV())
+		if retry {
+			// the changes may be missed before the watch is recreated.
+			notifyReload(reload)
+		}
+		for resp := range wch {
+			if err := resp.Err(); err != nil {
+				log.Warn("watch topology failed", zap.String("prefix", prefix), zap.Error(err))
+				break
+			}
+			for _, ev := range resp.Events {
+				if filter(ev) {
+					notifyReload(reload)
+					break
+				}
+			}
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// getStoreMetaPrefix returns the etcd prefix of the PD store meta, it retries until ctx is done.
+func (d *TopologyDiscoverer) getStoreMetaPrefix(ctx context.Context) (string, error) {
+	for {
+		resp, err := d.EtcdClient.Get(ctx, pdClusterIDKey)
+		if err == nil {
+			if len(resp.Kvs) == 0 {
+				err = fmt.Errorf("%v is not found", pdClusterIDKey)
+			} else {
+				var prefix string
+				prefix, err = storeMetaPrefix(resp.Kvs[0].Value)
+				if err == nil {
+					return prefix, nil
+				}
+			}
+		}
+		log.Warn("get pd cluster id failed", zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// storeMetaPrefix returns the etcd prefix of the PD store meta by the value of the cluster ID key, which is the
+// big endian encoded cluster ID.
+func storeMetaPrefix(clusterID []byte) (string, error) {
+	if len(clusterID) != 8 {
+		return "", fmt.Errorf("invalid pd cluster id %x", clusterID)
+	}
+	return fmt.Sprintf("/pd/%d/raft/s/", binary.BigEndian.Uint64(clusterID)), nil
+}
+
+// isTiDBTopologyChange returns true if the TiDB is started, stopped or its info is changed. TiDB refreshes the
+// ttl key and puts the same info periodically, they are ignored. The ttl key is deleted once its lease is expired.
+func isTiDBTopologyChange(ev *clientv3.Event) bool {
+	if ev.Type == clientv3.EventTypeDelete || ev.IsCreate() {
+		return true
+	}
+	return strings.HasSuffix(string(ev.Kv.Key), "/info") && isValueChange(ev)
+}
+
+// isValueChange returns false if the key is put with the same value as the previous one.
+func isValueChange(ev *clientv3.Event) bool {
+	if ev.Type == clientv3.EventTypeDelete || ev.PrevKv == nil {
+		return true
+	}
+	return !bytes.Equal(ev.PrevKv.Value, ev.Kv.Value)
+}
+
+// runReloadLoop calls load at first, then once reload is notified or every resync interval. The notifications
+// during the debounce are coalesced into one load.
+func runReloadLoop(ctx context.Context, reload <-chan struct{}, resync time.Duration, load func()) {
+	load()
+	ticker := time.NewTicker(resync)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-reload:
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(watchDebounce):
+			}
+			// the changes during the debounce are included in this load.
+			select {
+			case <-reload:
+			default:
+			}
+			ticker.Reset(resync)
+		}
+		load()
+	}
+}
+
+func notifyReload(reload chan<- struct{}) {
+	select {
+	case reload <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,188 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/embed"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+func newTestEtcd(t *testing.T) *clientv3.Client {
+	freeURL := func() url.URL {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		return url.URL{Scheme: "http", Host: l.Addr().String()}
+	}
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(), freeURL()
+	cfg.LCUrls, cfg.ACUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.LPUrls, cfg.APUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e, err := embed.StartEtcd(cfg)
+	require.NoError(t, err)
+	t.Cleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "start etcd timeout")
+	}
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{clientURL.String()}})
+	require.NoError(t, err)
+	t.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+func TestWatchTopology(t *testing.T) {
+	cli := newTestEtcd(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := &TopologyDiscoverer{EtcdClient: cli}
+	reload := make(chan struct{}, 1)
+
+	put := func(key, value string) {
+		_, err := cli.Put(ctx, key, value)
+		require.NoError(t, err)
+	}
+	expectReload := func(expected bool) {
+		select {
+		case <-reload:
+			require.True(t, expected, "unexpected reload")
+		case <-time.After(300 * time.Millisecond):
+			require.False(t, expected, "reload is not notified")
+		}
+	}
+
+	_, err := cli.Put(ctx, pdClusterIDKey, string([]byte{0, 0, 0, 0, 0, 0, 0x30, 0x39}))
+	require.NoError(t, err)
+	d.watchTopology(ctx, reload)
+	// wait until the watches are created.
+	require.Eventually(t, func() bool {
+		put("/pd/12345/raft/s/00000000000000000000", time.Now().String())
+		select {
+		case <-reload:
+			return true
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		put(fmt.Sprintf("/topology/tidb/warmup:%v/ttl", time.Now().UnixNano()), "1")
+		select {
+		case <-reload:
+			return true
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, time.Millisecond)
+
+	info := `{"version":"5.2.1","git_hash":"abc","status_port":10080,"start_timestamp":1}`
+	put("/topology/tidb/10.0.1.1:4000/info", info)
+	expectReload(true)
+	put("/topology/tidb/10.0.1.1:4000/ttl", "100")
+	expectReload(true)
+	// the periodic refresh doesn't reload the topology.
+	put("/topology/tidb/10.0.1.1:4000/ttl", "200")
+	put("/topology/tidb/10.0.1.1:4000/info", info)
+	expectReload(false)
+	put("/topology/tidb/10.0.1.1:4000/info", `{"version":"5.2.2"}`)
+	expectReload(true)
+	_, err = cli.Delete(ctx, "/topology/tidb/10.0.1.1:4000/ttl")
+	require.NoError(t, err)
+	expectReload(true)
+
+	put("/pd/12345/raft/s/00000000000000000001", "store-1")
+	expectReload(true)
+	put("/pd/12345/raft/s/00000000000000000001", "store-1")
+	expectReload(false)
+	put("/pd/12345/raft/s/00000000000000000001", "store-1-offline")
+	expectReload(true)
+	// the other keys of pd are not watched.
+	put("/pd/12345/timestamp", "1")
+	expectReload(false)
+}
+
+func TestRunReloadLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reload := make(chan struct{}, 1)
+	loaded := make(chan struct{}, 10)
+	done := make(chan struct{})
+	go func() {
+		runReloadLoop(ctx, reload, time.Hour, func() {
+			loaded <- struct{}{}
+		})
+		close(done)
+	}()
+	<-loaded
+
+	// the burst of changes is coalesced into one load.
+	for i := 0; i < 5; i++ {
+		notifyReload(reload)
+		time.Sleep(watchDebounce / 10)
+	}
+	select {
+	case <-loaded:
+	case <-time.After(time.Second):
+		require.FailNow(t, "load is not called")
+	}
+	select {
+	case <-loaded:
+		require.FailNow(t, "the changes should be coalesced")
+	case <-time.After(2 * watchDebounce):
+	}
+	cancel()
+	<-done
+
+	// the topology is resynced periodically without the changes.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go runReloadLoop(ctx, reload, 50*time.Millisecond, func() {
+		loaded <- struct{}{}
+	})
+	for i := 0; i < 3; i++ {
+		select {
+		case <-loaded:
+		case <-time.After(time.Second):
+			require.FailNow(t, "resync is not called")
+		}
+	}
+}
+
+func TestTopologyWatchFilter(t *testing.T) {
+	put := func(key, value, prevValue string, createRev, modRev int64) *clientv3.Event {
+		ev := &clientv3.Event{
+			Type: clientv3.EventTypePut,
+			Kv:   &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), CreateRevision: createRev, ModRevision: modRev},
+		}
+		if prevValue != "" {
+			ev.PrevKv = &mvccpb.KeyValue{Key: []byte(key), Value: []byte(prevValue)}
+		}
+		return ev
+	}
+	require.True(t, isTiDBTopologyChange(put("/topology/tidb/10.0.1.1:4000/info", "a", "", 10, 10)))
+	require.True(t, isTiDBTopologyChange(put("/topology/tidb/10.0.1.1:4000/info", "b", "a", 10, 20)))
+	require.False(t, isTiDBTopologyChange(put("/topology/tidb/10.0.1.1:4000/info", "a", "a", 10, 20)))
+	// the previous value may be compacted.
+	require.True(t, isTiDBTopologyChange(put("/topology/tidb/10.0.1.1:4000/info", "a", "", 10, 20)))
+	require.True(t, isTiDBTopologyChange(put("/topology/tidb/10.0.1.1:4000/ttl", "1", "", 11, 11)))
+	require.False(t, isTiDBTopologyChange(put("/topology/tidb/10.0.1.1:4000/ttl", "2", "1", 11, 30)))
+	require.True(t, isTiDBTopologyChange(&clientv3.Event{
+		Type: clientv3.EventTypeDelete,
+		Kv:   &mvccpb.KeyValue{Key: []byte("/topology/tidb/10.0.1.1:4000/ttl"), ModRevision: 40},
+	}))
+
+	prefix, err := storeMetaPrefix([]byte{0, 0, 0, 0, 0, 0, 0x30, 0x39})
+	require.NoError(t, err)
+	require.Equal(t, "/pd/12345/raft/s/", prefix)
+	_, err = storeMetaPrefix([]byte("12345"))
+	require.Error(t, err)
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/metrics"
 	"github.com/crazycs520/continuous-profile/scrape"
 	"github.com/crazycs520/continuous-profile/store"
 	"github.com/crazycs520/continuous-profile/util/logutil"
@@ -21,6 +22,12 @@ const (
 	nmConfig  = "config"
 	nmLogFile = "log-file"
 	nmPDAddr  = "pd-address"
+
+	nmProfilingEnable        = "profiling-enable"
+	nmProfileSeconds         = "profile-seconds"
+	nmProfilingInterval      = "profiling-interval-seconds"
+	nmProfilingTimeout       = "profiling-timeout-seconds"
+	nmProfilingDataRetention = "profiling-data-retention-seconds"
 )
 
 var (
@@ -29,6 +36,12 @@ var (
 	configPath = flag.String(nmConfig, "", "config file path")
 	logFile    = flag.String(nmLogFile, "", "log file name")
 	pdAddress  = flag.String(nmPDAddr, "127.0.0.1:2379", "PD address")
+
+	profilingEnable        = flag.Bool(nmProfilingEnable, config.DefProfilingEnable, "enable continuous profiling")
+	profileSeconds         = flag.Int(nmProfileSeconds, config.DefProfileSeconds, "duration of each CPU profile in seconds")
+	profilingInterval      = flag.Int(nmProfilingInterval, config.DefProfilingIntervalSeconds, "continuous profiling interval in seconds")
+	profilingTimeout       = flag.Int(nmProfilingTimeout, config.DefProfilingTimeoutSeconds, "continuous profiling scrape timeout in seconds")
+	profilingDataRetention = flag.Int(nmProfilingDataRetention, config.DefProfilingDataRetentionSeconds, "continuous profiling data retention in seconds")
 )
 
 func main() {
@@ -38,16 +51,18 @@ func main() {
 	mustBeNil(err)
 
 	setupLog()
+	metrics.RegisterMetrics()
 
 	cfg := config.GetGlobalConfig()
 	storage, err := store.NewProfileStorage(cfg.StorePath)
 	mustBeNil(err)
 
-	if cfg.PDAddr == "" {
-		mustBeNil(errors.New("need specify PD address"))
-	}
-	discoverer, err := discovery.NewTopologyDiscoverer(cfg.PDAddr, cfg.Security.GetTLSConfig())
+	discoverers, err := discovery.NewDiscoverers(cfg)
 	mustBeNil(err)
+	if len(discoverers) == 0 && len(cfg.ScrapeConfigs) == 0 && !cfg.Ingest.Enable {
+		mustBeNil(errors.New("need specify PD address, discovery configs, scrape configs or enable ingest"))
+	}
+	discoverer := discovery.NewMultiDiscoverer(discoverers...)
 
 	manager := scrape.NewManager(storage, discoverer.Subscribe())
 	manager.Start()
@@ -59,6 +74,7 @@ func main() {
 
 	exited := make(chan struct{})
 	signal.SetupSignalHandler(func(graceful bool) {
+		discoverer.Close()
 		manager.Close()
 		server.Close()
 		close(exited)
@@ -93,6 +109,21 @@ func overrideConfig(cfg *config.Config) {
 	if actualFlags[nmPDAddr] {
 		cfg.PDAddr = *pdAddress
 	}
+	if actualFlags[nmProfilingEnable] {
+		cfg.ContinueProfiling.Enable = *profilingEnable
+	}
+	if actualFlags[nmProfileSeconds] {
+		cfg.ContinueProfiling.ProfileSeconds = *profileSeconds
+	}
+	if actualFlags[nmProfilingInterval] {
+		cfg.ContinueProfiling.IntervalSeconds = *profilingInterval
+	}
+	if actualFlags[nmProfilingTimeout] {
+		cfg.ContinueProfiling.TimeoutSeconds = *profilingTimeout
+	}
+	if actualFlags[nmProfilingDataRetention] {
+		cfg.ContinueProfiling.DataRetentionSeconds = *profilingDataRetention
+	}
 }
 
 func mustBeNil(err error) {
@@ -0,0 +1,85 @@
+package meta
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	// MatchEqual matches the label whose value equals to the matcher value.
+	MatchEqual = "="
+	// MatchNotEqual matches the label whose value doesn't equal to the matcher value.
+	MatchNotEqual = "!="
+	// MatchRegexp matches the label whose value fully matches the regular expression.
+	MatchRegexp = "=~"
+	// MatchNotRegexp matches the label whose value doesn't fully match the regular expression.
+	MatchNotRegexp = "!~"
+)
+
+// LabelMatcher matches the target label, the missing label is treated as the empty value, the same as Prometheus.
+type LabelMatcher struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+
+	re *regexp.Regexp
+}
+
+// Compile validates the matcher and compiles the regular expression.
+func (m *LabelMatcher) Compile() error {
+	if m.Name == "" {
+		return fmt.Errorf("the name of label matcher should not be empty")
+	}
+	switch m.Type {
+	case MatchEqual, MatchNotEqual:
+		return nil
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + m.Value + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid regexp `%v` of label matcher %v, %v", m.Value, m.Name, err)
+		}
+		m.re = re
+		return nil
+	default:
+		return fmt.Errorf("unsupported label matcher type `%v`, should be one of =, !=, =~ and !~", m.Type)
+	}
+}
+
+// Matches returns true if the labels match the matcher, the matcher should be compiled first.
+func (m *LabelMatcher) Matches(labels map[string]string) bool {
+	value := labels[m.Name]
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re != nil && m.re.MatchString(value)
+	case MatchNotRegexp:
+		return m.re != nil && !m.re.MatchString(value)
+	}
+	return false
+}
+
+// CompileLabelMatchers compiles all the label matchers of the query param.
+func (p *BasicQueryParam) CompileLabelMatchers() error {
+	for _, m := range p.LabelMatchers {
+		if m == nil {
+			return fmt.Errorf("label matcher should not be null")
+		}
+		if err := m.Compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MatchLabels returns true if the labels match all the label matchers of the query param.
+func (p *BasicQueryParam) MatchLabels(labels map[string]string) bool {
+	for _, m := range p.LabelMatchers {
+		if !m.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package meta
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelMatchers(t *testing.T) {
+	labels := map[string]string{"zone": "us-west-1a", "team": "storage"}
+	cases := []struct {
+		matcher LabelMatcher
+		matched bool
+	}{
+		{LabelMatcher{Name: "zone", Type: MatchEqual, Value: "us-west-1a"}, true},
+		{LabelMatcher{Name: "zone", Type: MatchEqual, Value: "us-west"}, false},
+		{LabelMatcher{Name: "zone", Type: MatchNotEqual, Value: "us-west"}, true},
+		{LabelMatcher{Name: "zone", Type: MatchRegexp, Value: "us-west-.*"}, true},
+		// the regexp is fully anchored.
+		{LabelMatcher{Name: "zone", Type: MatchRegexp, Value: "west"}, false},
+		{LabelMatcher{Name: "zone", Type: MatchNotRegexp, Value: "us-east-.*"}, true},
+		// the missing label is treated as the empty value.
+		{LabelMatcher{Name: "cluster", Type: MatchEqual, Value: ""}, true},
+		{LabelMatcher{Name: "cluster", Type: MatchNotEqual, Value: ""}, false},
+		{LabelMatcher{Name: "cluster", Type: MatchRegexp, Value: "prod|"}, true},
+	}
+	for _, c := range cases {
+		m := c.matcher
+		require.NoError(t, m.Compile())
+		require.Equal(t, c.matched, m.Matches(labels), "%v%v%v", m.Name, m.Type, m.Value)
+	}
+
+	param := &BasicQueryParam{LabelMatchers: []*LabelMatcher{
+		{Name: "zone", Type: MatchRegexp, Value: "us-.*"},
+		{Name: "team", Type: MatchEqual, Value: "storage"},
+	}}
+	require.NoError(t, param.CompileLabelMatchers())
+	require.True(t, param.MatchLabels(labels))
+	require.False(t, param.MatchLabels(map[string]string{"zone": "us-west-1a"}))
+	require.True(t, (&BasicQueryParam{}).MatchLabels(nil))
+
+	for _, m := range []*LabelMatcher{
+		{Name: "", Type: MatchEqual},
+		{Name: "zone", Type: "=="},
+		{Name: "zone", Type: MatchRegexp, Value: "("},
+	} {
+		require.Error(t, m.Compile())
+	}
+	require.Error(t, (&BasicQueryParam{LabelMatchers: []*LabelMatcher{nil}}).CompileLabelMatchers())
+}
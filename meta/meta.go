@@ -9,15 +9,145 @@ type ProfileTarget struct {
 type TargetInfo struct {
 	ID           int64
 	LastScrapeTs int64
+	// Labels is the labels of the target, such as the zone of the TiKV store and the labels of the scrape config.
+	Labels map[string]string
+	// Build is the latest build info of the target, nil means unknown.
+	Build *TargetBuild
+	// Instance is the stable identity of the target, such as the TiKV store ID, empty means unknown.
+	Instance string
+	// Aliases is the previous addresses of the instance.
+	Aliases []TargetAlias
+}
+
+// TargetAlias is a previous address of the target instance, the profiles before EndTs may be scraped from it.
+type TargetAlias struct {
+	Address string `json:"address"`
+	EndTs   int64  `json:"end_time"`
+}
+
+// BuildInfo is the build metadata of the binary which runs on the target.
+type BuildInfo struct {
+	Version string `json:"version"`
+	GitHash string `json:"git_hash"`
+	// StartTime is the unix timestamp when the target process started, 0 means unknown.
+	StartTime int64 `json:"start_time"`
+}
+
+// IsEmpty returns true if the build info is unknown.
+func (b BuildInfo) IsEmpty() bool {
+	return b == BuildInfo{}
+}
+
+// TargetBuild is the build info of the target in the time range [BeginTs, EndTs], the time range is from the
+// first to the last profile scraped with this build info.
+type TargetBuild struct {
+	BuildInfo
+	BeginTs int64 `json:"begin_time"`
+	EndTs   int64 `json:"end_time"`
+}
+
+const (
+	// ProfileKindProfile is the kind of cpu profile.
+	ProfileKindProfile = "profile"
+	// ProfileKindTrace is the kind of go execution trace.
+	ProfileKindTrace = "trace"
+)
+
+const (
+	// ProfileFormatProtobuf is the pprof protobuf format.
+	ProfileFormatProtobuf = "protobuf"
+	// ProfileFormatText is the text format, such as goroutine profile with debug=2.
+	ProfileFormatText = "text"
+	// ProfileFormatTrace is the go execution trace format.
+	ProfileFormatTrace = "trace"
+	// ProfileFormatJemalloc is the jemalloc heap profile format of TiKV and TiFlash.
+	ProfileFormatJemalloc = "jemalloc"
+)
+
+const (
+	// ProfileTagManual is the tag of the profile which is captured by the capture API.
+	ProfileTagManual = "manual"
+	// ProfileTagPush is the tag of the profile which is pushed by the ingest API.
+	ProfileTagPush = "push"
+)
+
+// ProfileAttr is the attributes stored along with each profile.
+type ProfileAttr struct {
+	Format string `json:"format"`
+	Tag    string `json:"tag,omitempty"`
+	Note   string `json:"note,omitempty"`
+	// Round is the unix timestamp of the aligned scrape round, the profiles of the same kind with the
+	// same round cover the same wall-clock window. 0 means the profile is not scraped in aligned mode.
+	Round int64 `json:"round,omitempty"`
+	// Labels is the labels of the pushed profile.
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 type BasicQueryParam struct {
 	Begin   int64           `json:"begin_time"`
 	End     int64           `json:"end_time"`
 	Targets []ProfileTarget `json:"targets"`
+	// Kinds filters the targets by profile kind, empty means all kinds.
+	Kinds []string `json:"kinds"`
+	// Tag filters the profiles by tag, empty means all profiles.
+	Tag string `json:"tag"`
+	// Round filters the profiles by aligned scrape round, 0 means all profiles.
+	Round int64 `json:"round"`
+	// GroupBy groups the result of list and download, only support `round` now.
+	GroupBy string `json:"group_by"`
+	// LabelMatchers filters the targets by the target labels, all the matchers should match.
+	LabelMatchers []*LabelMatcher `json:"label_matchers"`
+	// Instances filters the targets by the instance identity, such as `store-1`, empty means all targets.
+	Instances []string `json:"instances"`
+}
+
+const (
+	// GroupByRound groups the profiles by the aligned scrape round.
+	GroupByRound = "round"
+)
+
+// MatchInstance returns true if the instance matches the instances filter of the query param.
+func (p *BasicQueryParam) MatchInstance(instance string) bool {
+	if len(p.Instances) == 0 {
+		return true
+	}
+	for _, ins := range p.Instances {
+		if ins == instance {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchKind returns true if the kind matches the kinds filter of the query param.
+func (p *BasicQueryParam) MatchKind(kind string) bool {
+	if len(p.Kinds) == 0 {
+		return true
+	}
+	for _, k := range p.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
 }
 
 type ProfileList struct {
 	Target ProfileTarget `json:"target"`
-	TsList []int64       `json:"timestamp_list"`
+	// Labels is the labels of the target.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Builds is the build info of the target which overlaps the query time range, ordered by time.
+	Builds []TargetBuild `json:"builds,omitempty"`
+	// Instance is the stable identity of the target, the profiles of its previous addresses are included.
+	Instance string        `json:"instance,omitempty"`
+	Aliases  []TargetAlias `json:"aliases,omitempty"`
+	TsList   []int64       `json:"timestamp_list"`
+	// Attrs is the attributes of each profile in TsList.
+	Attrs []ProfileAttr `json:"attrs,omitempty"`
+}
+
+// ProfileRound is the profiles of an aligned scrape round.
+type ProfileRound struct {
+	Round    int64         `json:"round"`
+	Profiles []ProfileList `json:"profiles"`
 }
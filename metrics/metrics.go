@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "conprof"
+
+	LblComponent = "component"
+	LblKind      = "kind"
+	LblType      = "type"
+)
+
+var (
+	ScrapeCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "scrape",
+			Name:      "total",
+			Help:      "Counter of scrape attempts.",
+		}, []string{LblComponent, LblKind})
+
+	ScrapeErrorCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "scrape",
+			Name:      "errors_total",
+			Help:      "Counter of failed scrapes.",
+		}, []string{LblComponent, LblKind})
+
+	ScrapeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "scrape",
+			Name:      "duration_seconds",
+			Help:      "Bucketed histogram of scrape duration.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16), // 10ms ~ 327s
+		}, []string{LblComponent, LblKind})
+
+	ScrapeWaitDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "scrape",
+			Name:      "wait_duration_seconds",
+			Help:      "Bucketed histogram of the time waiting for the scrape concurrency limit.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 20), // 1ms ~ 524s
+		}, []string{LblComponent, LblKind})
+
+	ScrapeSkippedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "scrape",
+			Name:      "skipped_total",
+			Help:      "Counter of the overdue scrapes which are coalesced into a later scrape.",
+		}, []string{LblComponent, LblKind})
+
+	ScrapeQueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "scrape",
+			Name:      "queue_depth",
+			Help:      "Number of the due scrapes which are waiting for a worker.",
+		})
+
+	ScrapeBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "scrape",
+			Name:      "bytes_total",
+			Help:      "Counter of scraped profile bytes.",
+		}, []string{LblComponent, LblKind})
+
+	StoreBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "store",
+			Name:      "bytes_total",
+			Help:      "Counter of stored profile bytes.",
+		}, []string{LblComponent, LblKind})
+
+	IngestBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ingest",
+			Name:      "bytes_total",
+			Help:      "Counter of pushed profile bytes.",
+		}, []string{LblComponent, LblKind})
+
+	StoreWriteDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "store",
+			Name:      "write_duration_seconds",
+			Help:      "Bucketed histogram of profile write duration.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16), // 0.5ms ~ 16s
+		})
+
+	GCDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "gc",
+			Name:      "duration_seconds",
+			Help:      "Bucketed histogram of gc duration.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16), // 10ms ~ 327s
+		})
+
+	GCDroppedTablesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "gc",
+			Name:      "dropped_tables_total",
+			Help:      "Counter of stale target tables dropped by gc.",
+		})
+
+	DiscoveredComponentsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "discovery",
+			Name:      "components",
+			Help:      "Number of discovered components.",
+		}, []string{LblComponent})
+
+	DiscoveryFileErrorCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "discovery",
+			Name:      "file_errors_total",
+			Help:      "Counter of the discovery files which can't be read or are malformed.",
+		})
+
+	BadgerSizeGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "store",
+			Name:      "badger_size_bytes",
+			Help:      "Size of the badger LSM tree and value log, it's updated by each gc run, so it may be up to 60s stale.",
+		}, []string{LblType})
+)
+
+// RegisterMetrics registers the metrics which are used by conprof.
+func RegisterMetrics() {
+	prometheus.MustRegister(ScrapeCounter)
+	prometheus.MustRegister(ScrapeErrorCounter)
+	prometheus.MustRegister(ScrapeDuration)
+	prometheus.MustRegister(ScrapeWaitDuration)
+	prometheus.MustRegister(ScrapeSkippedCounter)
+	prometheus.MustRegister(ScrapeQueueDepth)
+	prometheus.MustRegister(ScrapeBytesCounter)
+	prometheus.MustRegister(StoreBytesCounter)
+	prometheus.MustRegister(IngestBytesCounter)
+	prometheus.MustRegister(StoreWriteDuration)
+	prometheus.MustRegister(GCDuration)
+	prometheus.MustRegister(GCDroppedTablesCounter)
+	prometheus.MustRegister(DiscoveredComponentsGauge)
+	prometheus.MustRegister(DiscoveryFileErrorCounter)
+	prometheus.MustRegister(BadgerSizeGauge)
+}
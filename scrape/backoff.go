@@ -0,0 +1,108 @@
+package scrape
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	CircuitClosed = "closed"
+	CircuitOpen   = "open"
+)
+
+const (
+	// quickRetryDelay is the delay of the first retry after a failure.
+	quickRetryDelay = 5 * time.Second
+	// maxBackoffDelay is the max delay between the retries, the scrape interval is used if it's greater.
+	maxBackoffDelay = 10 * time.Minute
+	// maxProbeTimeout is the max timeout of the probe which checks whether the target is reachable.
+	maxProbeTimeout = 3 * time.Second
+)
+
+// backoff decides when to scrape the target next time. After a failure, it retries quickly once, then
+// backs off exponentially up to a cap. The connection refused error opens the circuit, the scrape is
+// replaced by a cheap probe until the probe succeeds.
+type backoff struct {
+	interval time.Duration
+	failures int
+	open     bool
+}
+
+func newBackoff(interval time.Duration) *backoff {
+	return &backoff{interval: interval}
+}
+
+// onScrape returns the delay of the next scrape after a scrape finished.
+func (b *backoff) onScrape(err error) time.Duration {
+	if err == nil {
+		b.failures = 0
+		return b.interval
+	}
+	b.failures++
+	if isConnRefused(err) {
+		b.open = true
+	}
+	return b.retryDelay()
+}
+
+// onProbe returns the delay of the next scrape or probe after a probe finished.
+func (b *backoff) onProbe(err error) time.Duration {
+	if err == nil {
+		// the target is reachable again, scrape it right now.
+		b.open = false
+		return 0
+	}
+	b.failures++
+	return b.retryDelay()
+}
+
+func (b *backoff) retryDelay() time.Duration {
+	if b.failures <= 1 {
+		if b.interval < quickRetryDelay {
+			return b.interval
+		}
+		return quickRetryDelay
+	}
+	limit := maxBackoffDelay
+	if b.interval > limit {
+		limit = b.interval
+	}
+	delay := b.interval
+	for i := 2; i < b.failures && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+func (b *backoff) circuit() string {
+	if b.open {
+		return CircuitOpen
+	}
+	return CircuitClosed
+}
+
+func isConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// probe checks whether the address accepts the tcp connection.
+func probe(ctx context.Context, address string, timeout time.Duration) error {
+	if timeout <= 0 || timeout > maxProbeTimeout {
+		timeout = maxProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
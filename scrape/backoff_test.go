@@ -0,0 +1,48 @@
+package scrape
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	bo := newBackoff(time.Minute)
+	require.Equal(t, time.Minute, bo.onScrape(nil))
+
+	// retry quickly once, then back off exponentially up to the cap.
+	timeoutErr := errors.New("context deadline exceeded")
+	require.Equal(t, quickRetryDelay, bo.onScrape(timeoutErr))
+	require.Equal(t, time.Minute, bo.onScrape(timeoutErr))
+	require.Equal(t, 2*time.Minute, bo.onScrape(timeoutErr))
+	require.Equal(t, 4*time.Minute, bo.onScrape(timeoutErr))
+	require.Equal(t, 8*time.Minute, bo.onScrape(timeoutErr))
+	require.Equal(t, maxBackoffDelay, bo.onScrape(timeoutErr))
+	require.Equal(t, maxBackoffDelay, bo.onScrape(timeoutErr))
+	require.Equal(t, CircuitClosed, bo.circuit())
+	require.Equal(t, time.Minute, bo.onScrape(nil))
+
+	// the short interval is not delayed by the quick retry.
+	require.Equal(t, time.Second, newBackoff(time.Second).onScrape(timeoutErr))
+
+	// the connection refused error opens the circuit, and the succeeded probe closes it.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, probe(context.Background(), addr, time.Second))
+	require.NoError(t, ln.Close())
+	err = probe(context.Background(), addr, time.Second)
+	require.True(t, isConnRefused(errors.Wrap(err, "scrape failed")))
+
+	bo = newBackoff(time.Minute)
+	require.Equal(t, quickRetryDelay, bo.onScrape(err))
+	require.Equal(t, CircuitOpen, bo.circuit())
+	require.Equal(t, time.Minute, bo.onProbe(err))
+	require.Equal(t, CircuitOpen, bo.circuit())
+	require.Equal(t, time.Duration(0), bo.onProbe(nil))
+	require.Equal(t, CircuitClosed, bo.circuit())
+}
@@ -0,0 +1,151 @@
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/discovery"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/crazycs520/continuous-profile/util"
+	"github.com/pingcap/log"
+	commonconfig "github.com/prometheus/common/config"
+	"go.uber.org/zap"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+const (
+	buildFetchTimeout     = 3 * time.Second
+	buildFetchConcurrency = 16
+	// maxBuildResponseSize limits the response of the status endpoint.
+	maxBuildResponseSize = 1024 * 1024
+)
+
+// buildStatusPath is the status endpoint which reports the build info of the component. The components which are
+// not in it have no such endpoint, such as TiKV and TiFlash.
+var buildStatusPath = map[string]string{
+	discovery.ComponentTiDB: "/info",
+	discovery.ComponentPD:   "/pd/api/v1/status",
+}
+
+// buildFetcher fetches the build info of the components from their status endpoints, so the components which
+// are not discovered from the PD topology, such as the ones in the scrape_configs, have the build info too. The
+// build info in the PD topology is used if the component has no status endpoint or it's never fetched.
+type buildFetcher struct {
+	// refreshMu serializes the refreshes.
+	refreshMu sync.Mutex
+
+	mu     sync.Mutex
+	builds map[discovery.ComponentKey]meta.BuildInfo
+}
+
+func newBuildFetcher() *buildFetcher {
+	return &buildFetcher{builds: make(map[discovery.ComponentKey]meta.BuildInfo)}
+}
+
+// get returns the build info of the component.
+func (f *buildFetcher) get(comp discovery.Component) meta.BuildInfo {
+	if f != nil {
+		f.mu.Lock()
+		build, ok := f.builds[comp.Key()]
+		f.mu.Unlock()
+		if ok {
+			return build
+		}
+	}
+	return componentBuild(comp)
+}
+
+// refresh fetches the build info of the components, the components which are removed are dropped. The last
+// fetched build info of a component is kept if the fetch failed, such as the component is restarting.
+func (f *buildFetcher) refresh(ctx context.Context, components []discovery.Component) {
+	f.refreshMu.Lock()
+	defer f.refreshMu.Unlock()
+	cfg := config.GetGlobalConfig()
+	client, err := commonconfig.NewClientFromConfig(cfg.Security.GetHTTPClientConfig(), "build")
+	if err != nil {
+		log.Error("create the http client of fetching build info failed", zap.Error(err))
+		return
+	}
+	scheme := cfg.GetHTTPScheme()
+
+	keys := make(map[discovery.ComponentKey]struct{}, len(components))
+	sem := make(chan struct{}, buildFetchConcurrency)
+	var wg sync.WaitGroup
+	for _, comp := range components {
+		keys[comp.Key()] = struct{}{}
+		path, ok := buildStatusPath[comp.Name]
+		if !ok {
+			continue
+		}
+		comp := comp
+		sem <- struct{}{}
+		wg.Add(1)
+		go util.GoWithRecovery(func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			url := fmt.Sprintf("%v://%v:%v%v", scheme, comp.IP, comp.StatusPort, path)
+			build, err := fetchBuild(ctx, client, url)
+			if err != nil {
+				log.Debug("fetch build info failed",
+					zap.String("component", comp.Name),
+					zap.String("url", url),
+					zap.Error(err))
+				return
+			}
+			f.mu.Lock()
+			f.builds[comp.Key()] = build
+			f.mu.Unlock()
+		}, nil)
+	}
+	wg.Wait()
+
+	f.mu.Lock()
+	for key := range f.builds {
+		if _, ok := keys[key]; !ok {
+			delete(f.builds, key)
+		}
+	}
+	f.mu.Unlock()
+}
+
+// fetchBuild fetches the build info from the status endpoint, the version of TiDB such as `5.7.25-TiDB-v5.3.0`
+// is trimmed to `v5.3.0`, which is the same as the version in the PD topology.
+func fetchBuild(ctx context.Context, client *http.Client, url string) (meta.BuildInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, buildFetchTimeout)
+	defer cancel()
+	resp, err := ctxhttp.Get(ctx, client, url)
+	if err != nil {
+		return meta.BuildInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return meta.BuildInfo{}, fmt.Errorf("server returned HTTP status %s", resp.Status)
+	}
+	var status struct {
+		Version        string `json:"version"`
+		GitHash        string `json:"git_hash"`
+		StartTimestamp int64  `json:"start_timestamp"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxBuildResponseSize)).Decode(&status)
+	if err != nil {
+		return meta.BuildInfo{}, err
+	}
+	version := status.Version
+	if idx := strings.Index(version, "-TiDB-"); idx >= 0 {
+		version = version[idx+len("-TiDB-"):]
+	}
+	build := meta.BuildInfo{Version: version, GitHash: status.GitHash, StartTime: status.StartTimestamp}
+	if build.IsEmpty() {
+		return build, fmt.Errorf("no build info in the response")
+	}
+	return build, nil
+}
@@ -0,0 +1,68 @@
+package scrape
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/discovery"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildFetcher(t *testing.T) {
+	var fail int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) > 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		switch r.URL.Path {
+		case "/info":
+			w.Write([]byte(`{"version":"5.7.25-TiDB-v5.3.0","git_hash":"b2","start_timestamp":250}`))
+		case "/pd/api/v1/status":
+			w.Write([]byte(`{"version":"v5.3.0","git_hash":"c3","start_timestamp":260}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+	config.StoreGlobalConfig(config.NewConfig())
+
+	tidb, err := discovery.NewComponent(discovery.ComponentTiDB, address, nil)
+	require.NoError(t, err)
+	// the topology build info is overridden by the fetched one.
+	tidb.Version, tidb.GitHash = "v5.2.0", "a1"
+	pd, err := discovery.NewComponent(discovery.ComponentPD, address, nil)
+	require.NoError(t, err)
+	tikv, err := discovery.NewComponent(discovery.ComponentTiKV, address, nil)
+	require.NoError(t, err)
+	tikv.Version = "v5.3.0"
+
+	f := newBuildFetcher()
+	require.Equal(t, meta.BuildInfo{Version: "v5.2.0", GitHash: "a1"}, f.get(tidb))
+	f.refresh(context.Background(), []discovery.Component{tidb, pd, tikv})
+	require.Equal(t, meta.BuildInfo{Version: "v5.3.0", GitHash: "b2", StartTime: 250}, f.get(tidb))
+	require.Equal(t, meta.BuildInfo{Version: "v5.3.0", GitHash: "c3", StartTime: 260}, f.get(pd))
+	// the component has no status endpoint of build info.
+	require.Equal(t, meta.BuildInfo{Version: "v5.3.0"}, f.get(tikv))
+
+	// the last fetched build info is kept if the fetch failed.
+	atomic.StoreInt32(&fail, 1)
+	f.refresh(context.Background(), []discovery.Component{tidb, pd, tikv})
+	require.Equal(t, meta.BuildInfo{Version: "v5.3.0", GitHash: "b2", StartTime: 250}, f.get(tidb))
+
+	// the removed component is dropped.
+	f.refresh(context.Background(), []discovery.Component{pd})
+	require.Equal(t, meta.BuildInfo{Version: "v5.2.0", GitHash: "a1"}, f.get(tidb))
+	require.Equal(t, meta.BuildInfo{Version: "v5.3.0", GitHash: "c3", StartTime: 260}, f.get(pd))
+
+	// the suite without the fetcher uses the build info of the component.
+	var nilFetcher *buildFetcher
+	require.Equal(t, meta.BuildInfo{Version: "v5.2.0", GitHash: "a1"}, nilFetcher.get(tidb))
+}
@@ -0,0 +1,199 @@
+package scrape
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/discovery"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+const (
+	// MaxBurstSeconds is the max duration of a burst.
+	MaxBurstSeconds = 2 * 60 * 60
+)
+
+// BurstParam is the param of starting a burst, the burst overrides the scrape interval of the
+// chosen targets for a bounded time window.
+type BurstParam struct {
+	// Targets is the targets to burst, the component is required and the empty address means all the
+	// addresses of the component. Empty means all the targets.
+	Targets []meta.ProfileTarget `json:"targets"`
+	// Kinds is the scrape interval seconds of each profile kind during the burst.
+	Kinds           map[string]int `json:"kinds"`
+	DurationSeconds int            `json:"duration_seconds"`
+}
+
+// Burst is a high-frequency scrape window which reverts automatically after the end time.
+type Burst struct {
+	ID int64 `json:"id"`
+	BurstParam
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	// timer reverts the scrape interval after the burst is finished, it's stopped if the burst is stopped.
+	timer *time.Timer
+}
+
+func (b *Burst) match(target meta.ProfileTarget) (time.Duration, bool) {
+	seconds, ok := b.Kinds[target.Kind]
+	if !ok {
+		return 0, false
+	}
+	if len(b.Targets) == 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	for _, t := range b.Targets {
+		if t.Component == target.Component && (t.Address == "" || t.Address == target.Address) {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+func (p *BurstParam) validate() error {
+	if p.DurationSeconds <= 0 || p.DurationSeconds > MaxBurstSeconds {
+		return fmt.Errorf("duration_seconds(%v) should be in range (0, %v]", p.DurationSeconds, MaxBurstSeconds)
+	}
+	if len(p.Kinds) == 0 {
+		return fmt.Errorf("kinds should not be empty")
+	}
+	for kind, seconds := range p.Kinds {
+		if seconds <= 0 {
+			return fmt.Errorf("the interval seconds(%v) of kind %v should be greater than 0", seconds, kind)
+		}
+	}
+	for _, t := range p.Targets {
+		if t.Component == "" {
+			return fmt.Errorf("the component of target should not be empty")
+		}
+	}
+	return nil
+}
+
+// validateBurst checks the burst interval of each matched target is greater than the profile seconds, otherwise
+// the next scrape is due before the current one is finished.
+func (m *Manager) validateBurst(burst *Burst) error {
+	cfg := config.GetGlobalConfig().ContinueProfiling
+	m.mu.Lock()
+	components := make([]discovery.Component, 0, len(m.lastComponents))
+	for _, comp := range m.lastComponents {
+		components = append(components, comp)
+	}
+	m.mu.Unlock()
+	for _, comp := range components {
+		if !cfg.ForComponent(comp.Name).Enable {
+			continue
+		}
+		for target, spec := range m.buildComponentSpecs(comp, cfg) {
+			interval, ok := burst.match(target)
+			if !ok || !spec.pprofConfig.IsEnabled() {
+				continue
+			}
+			if interval <= time.Duration(spec.pprofConfig.Seconds)*time.Second {
+				return fmt.Errorf("the interval seconds(%v) of kind %v should be greater than the profile seconds(%v) of %v %v",
+					burst.Kinds[target.Kind], target.Kind, spec.pprofConfig.Seconds, target.Component, target.Address)
+			}
+		}
+	}
+	return nil
+}
+
+// StartBurst starts a burst, only the scrape suites of the chosen targets will be restarted. The targets
+// and the profile kinds which are disabled are not scraped during the burst.
+func (m *Manager) StartBurst(param *BurstParam) (*Burst, error) {
+	err := param.validate()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	duration := time.Duration(param.DurationSeconds) * time.Second
+	burst := &Burst{
+		BurstParam: *param,
+		StartTime:  now,
+		EndTime:    now.Add(duration),
+	}
+	err = m.validateBurst(burst)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.burstID++
+	burst.ID = m.burstID
+	m.bursts = append(m.bursts, burst)
+	// revert the scrape interval after the burst is finished.
+	burst.timer = time.AfterFunc(duration, m.NotifyReload)
+	m.mu.Unlock()
+
+	log.Info("start burst",
+		zap.Int64("id", burst.ID),
+		zap.Reflect("targets", burst.Targets),
+		zap.Reflect("kinds", burst.Kinds),
+		zap.Duration("duration", duration))
+	m.NotifyReload()
+	return burst, nil
+}
+
+// StopBurst stops the burst before its end time.
+func (m *Manager) StopBurst(id int64) error {
+	m.mu.Lock()
+	found := false
+	for i, burst := range m.bursts {
+		if burst.ID == id {
+			burst.timer.Stop()
+			m.bursts = append(m.bursts[:i], m.bursts[i+1:]...)
+			found = true
+			break
+		}
+	}
+	m.mu.Unlock()
+	if !found {
+		return fmt.Errorf("burst %v is not found", id)
+	}
+	log.Info("stop burst", zap.Int64("id", id))
+	m.NotifyReload()
+	return nil
+}
+
+// GetActiveBursts returns the bursts which are not finished.
+func (m *Manager) GetActiveBursts() []Burst {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	active := m.bursts[:0]
+	for _, burst := range m.bursts {
+		if now.Before(burst.EndTime) {
+			active = append(active, burst)
+		}
+	}
+	m.bursts = active
+
+	bursts := make([]Burst, 0, len(active))
+	for _, burst := range active {
+		bursts = append(bursts, *burst)
+	}
+	sort.Slice(bursts, func(i, j int) bool {
+		return bursts[i].ID < bursts[j].ID
+	})
+	return bursts
+}
+
+// getBurstInterval returns the smallest burst interval of the target.
+func getBurstInterval(bursts []Burst, target meta.ProfileTarget) (time.Duration, bool) {
+	var interval time.Duration
+	found := false
+	for i := range bursts {
+		d, ok := bursts[i].match(target)
+		if !ok {
+			continue
+		}
+		if !found || d < interval {
+			interval = d
+			found = true
+		}
+	}
+	return interval, found
+}
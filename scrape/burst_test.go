@@ -0,0 +1,52 @@
+package scrape
+
+import (
+	"testing"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/discovery"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBurst(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.ContinueProfiling.Enable = true
+	cfg.ContinueProfiling.ProfileSeconds = 10
+	disabled := false
+	cfg.ContinueProfiling.Components = map[string]config.ComponentProfilingConfig{
+		discovery.ComponentTiKV: {Enable: &disabled},
+		discovery.ComponentTiDB: {PprofConfig: config.PprofConfig{"goroutine": {Enabled: &disabled}}},
+	}
+	config.StoreGlobalConfig(cfg)
+	m := NewManager(nil, nil)
+	m.lastComponents = m.buildComponentMap([]discovery.Component{
+		{Name: discovery.ComponentTiDB, IP: "10.0.1.1", Port: 4000, StatusPort: 10080},
+		{Name: discovery.ComponentTiKV, IP: "10.0.1.2", Port: 20160, StatusPort: 20180},
+	})
+
+	// the burst interval should be greater than the profile seconds.
+	_, err := m.StartBurst(&BurstParam{Kinds: map[string]int{"profile": 10}, DurationSeconds: 60})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "profile seconds(10)")
+	require.Len(t, m.GetActiveBursts(), 0)
+
+	burst, err := m.StartBurst(&BurstParam{Kinds: map[string]int{"profile": 11, "goroutine": 1}, DurationSeconds: 60})
+	require.NoError(t, err)
+	specs := m.buildScrapeSpecs(cfg.ContinueProfiling)
+	tidbProfile := meta.ProfileTarget{Kind: "profile", Component: discovery.ComponentTiDB, Address: "10.0.1.1:10080"}
+	require.Equal(t, 11, int(specs[tidbProfile].interval.Seconds()))
+	// the disabled component and profile kind are not scraped during the burst.
+	for target := range specs {
+		require.NotEqual(t, discovery.ComponentTiKV, target.Component)
+		require.False(t, target.Component == discovery.ComponentTiDB && target.Kind == "goroutine")
+	}
+
+	// the timer of the stopped burst is stopped.
+	require.NoError(t, m.StopBurst(burst.ID))
+	require.False(t, burst.timer.Stop())
+	require.Len(t, m.GetActiveBursts(), 0)
+	require.Error(t, m.StopBurst(burst.ID))
+	specs = m.buildScrapeSpecs(cfg.ContinueProfiling)
+	require.Equal(t, cfg.ContinueProfiling.IntervalSeconds, int(specs[tidbProfile].interval.Seconds()))
+}
@@ -0,0 +1,218 @@
+package scrape
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/discovery"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/crazycs520/continuous-profile/store"
+	"github.com/crazycs520/continuous-profile/util"
+	"github.com/pingcap/log"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// MaxCaptureSeconds is the max profile seconds of the capture API.
+	MaxCaptureSeconds = 10 * 60
+	// maxCaptureTsRetry is the max retry count when the timestamp is used by other profile.
+	maxCaptureTsRetry = 10
+)
+
+// CaptureParam is the param of capturing profiles immediately.
+type CaptureParam struct {
+	// Targets is the targets to capture, only the component and address are used. Empty means all the components.
+	Targets []meta.ProfileTarget `json:"targets"`
+	// Kinds is the profile kinds to capture. Empty means all the enabled profile kinds.
+	Kinds []string `json:"kinds"`
+	// Seconds overrides the seconds of the profile kind which has duration, such as profile and trace.
+	Seconds int    `json:"seconds"`
+	Note    string `json:"note"`
+}
+
+// CaptureResult is the result of capturing a profile.
+type CaptureResult struct {
+	Target meta.ProfileTarget `json:"target"`
+	// Ts is the timestamp of the stored profile.
+	Ts    int64  `json:"ts,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Capture scrapes the profiles of the targets immediately and stores them with the manual tag.
+func (m *Manager) Capture(ctx context.Context, param *CaptureParam) ([]CaptureResult, error) {
+	if param.Seconds < 0 || param.Seconds > MaxCaptureSeconds {
+		return nil, fmt.Errorf("seconds(%v) should be in range [0, %v]", param.Seconds, MaxCaptureSeconds)
+	}
+	specs, err := m.buildCaptureSpecs(param)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]CaptureResult, 0, len(specs))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for target, spec := range specs {
+		target, spec := target, spec
+		wg.Add(1)
+		go util.GoWithRecovery(func() {
+			defer wg.Done()
+			result := m.capture(ctx, target, spec, param.Note)
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+		}, nil)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		ti, tj := results[i].Target, results[j].Target
+		if ti.Component != tj.Component {
+			return ti.Component < tj.Component
+		}
+		if ti.Address != tj.Address {
+			return ti.Address < tj.Address
+		}
+		return ti.Kind < tj.Kind
+	})
+	return results, nil
+}
+
+func (m *Manager) buildCaptureSpecs(param *CaptureParam) (map[meta.ProfileTarget]*scrapeSpec, error) {
+	m.mu.Lock()
+	components := make([]discovery.Component, 0, len(m.lastComponents))
+	for _, comp := range m.lastComponents {
+		components = append(components, comp)
+	}
+	m.mu.Unlock()
+
+	matched := make([]discovery.Component, 0, len(components))
+	if len(param.Targets) == 0 {
+		matched = components
+	}
+	for _, target := range param.Targets {
+		found := false
+		for _, comp := range components {
+			if comp.Name == target.Component && fmt.Sprintf("%v:%v", comp.IP, comp.StatusPort) == target.Address {
+				matched = append(matched, comp)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("target %v %v is not found", target.Component, target.Address)
+		}
+	}
+
+	continueProfilingCfg := config.GetGlobalConfig().ContinueProfiling
+	specs := make(map[meta.ProfileTarget]*scrapeSpec)
+	for _, comp := range matched {
+		for target, spec := range m.buildComponentSpecs(comp, continueProfilingCfg) {
+			if len(param.Kinds) > 0 {
+				if !containsString(param.Kinds, target.Kind) {
+					continue
+				}
+			} else if !spec.pprofConfig.IsEnabled() {
+				continue
+			}
+			if param.Seconds > 0 && spec.pprofConfig.Seconds > 0 {
+				spec.pprofConfig.Seconds = param.Seconds
+				if seconds := time.Duration(param.Seconds) * time.Second; spec.timeout <= seconds {
+					spec.timeout += seconds
+				}
+			}
+			specs[target] = spec
+		}
+	}
+	if len(specs) == 0 {
+		return nil, errors.New("no profile to capture")
+	}
+	return specs, nil
+}
+
+func (m *Manager) capture(ctx context.Context, target meta.ProfileTarget, spec *scrapeSpec, note string) CaptureResult {
+	result := CaptureResult{Target: target}
+	ts, err := m.captureAndStore(ctx, target, spec, note)
+	if err != nil {
+		log.Error("capture profile failed",
+			zap.String("component", target.Component),
+			zap.String("address", target.Address),
+			zap.String("kind", target.Kind),
+			zap.Error(err))
+		result.Error = err.Error()
+		return result
+	}
+	log.Info("capture profile finished",
+		zap.String("component", target.Component),
+		zap.String("address", target.Address),
+		zap.String("kind", target.Kind),
+		zap.Int64("ts", ts))
+	result.Ts = ts
+	return result
+}
+
+func (m *Manager) captureAndStore(ctx context.Context, target meta.ProfileTarget, spec *scrapeSpec, note string) (int64, error) {
+	source, err := newProfileSource(target, &spec.pprofConfig)
+	if err != nil {
+		return 0, err
+	}
+	release, err := m.limiter.acquire(ctx, target)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	buf := bytes.NewBuffer(nil)
+	scrapeCtx, cancel := context.WithTimeout(ctx, spec.timeout)
+	err = source.Collect(scrapeCtx, buf)
+	cancel()
+	release()
+	if err != nil {
+		return 0, err
+	}
+	format, _, err := detectFormat(buf.Bytes(), &spec.pprofConfig)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid profile")
+	}
+
+	attr := meta.ProfileAttr{
+		Format: format,
+		Tag:    meta.ProfileTagManual,
+		Note:   note,
+	}
+	err = m.store.BindProfileTargetInstance(target, spec.component.Instance)
+	if err != nil {
+		return 0, err
+	}
+	ts := util.GetTimeStamp(start)
+	for i := 0; ; i++ {
+		err = m.store.AddProfile(target, ts, buf.Bytes(), attr)
+		if err != store.ErrProfileExists || i >= maxCaptureTsRetry {
+			break
+		}
+		// the timestamp is used by the scheduled scrape, use the next second. The scheduled scrape which
+		// collides with it later is skipped, see scrapeAndStore.
+		ts++
+	}
+	if err != nil {
+		return 0, err
+	}
+	_, err = m.store.UpdateProfileTargetBuild(target, m.builds.get(spec.component), ts)
+	if err != nil {
+		return 0, err
+	}
+	return ts, nil
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package scrape
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/discovery"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/crazycs520/continuous-profile/store"
+	"github.com/crazycs520/continuous-profile/util"
+	"github.com/stretchr/testify/require"
+)
+
+const testGoroutineProfile = "goroutine 1 [running]:\nmain.main()\n"
+
+func newTestStorage(t *testing.T) *store.ProfileStorage {
+	config.StoreGlobalConfig(config.NewConfig())
+	st, err := store.NewProfileStorage(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func newTestManager(t *testing.T) (*Manager, *store.ProfileStorage, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testGoroutineProfile))
+	}))
+	t.Cleanup(server.Close)
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	st := newTestStorage(t)
+	m := NewManager(st, nil)
+	comp, err := discovery.NewComponent(discovery.ComponentTiDB, address, nil)
+	require.NoError(t, err)
+	m.lastComponents = m.buildComponentMap([]discovery.Component{comp})
+	return m, st, address
+}
+
+func TestCapture(t *testing.T) {
+	m, st, address := newTestManager(t)
+	ctx := context.Background()
+	tidb := meta.ProfileTarget{Component: discovery.ComponentTiDB, Address: address}
+
+	// invalid params
+	_, err := m.Capture(ctx, &CaptureParam{Seconds: MaxCaptureSeconds + 1})
+	require.Error(t, err)
+	_, err = m.Capture(ctx, &CaptureParam{Targets: []meta.ProfileTarget{{Component: "tikv", Address: address}}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not found")
+	_, err = m.Capture(ctx, &CaptureParam{Targets: []meta.ProfileTarget{tidb}, Kinds: []string{"unknown"}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no profile to capture")
+
+	// the seconds overrides the profile kinds which have duration, the timeout covers the seconds.
+	specs, err := m.buildCaptureSpecs(&CaptureParam{Kinds: []string{"profile", "goroutine"}, Seconds: 200})
+	require.NoError(t, err)
+	require.Len(t, specs, 2)
+	for target, spec := range specs {
+		if target.Kind == "profile" {
+			require.Equal(t, 200, spec.pprofConfig.Seconds)
+			require.Greater(t, spec.timeout, 200*time.Second)
+		} else {
+			require.Equal(t, 0, spec.pprofConfig.Seconds)
+		}
+	}
+	// all the enabled kinds are captured if the kinds is empty.
+	specs, err = m.buildCaptureSpecs(&CaptureParam{Targets: []meta.ProfileTarget{tidb}})
+	require.NoError(t, err)
+	for target, spec := range specs {
+		require.True(t, spec.pprofConfig.IsEnabled(), target.Kind)
+	}
+
+	// the timestamps taken by the scheduled scrapes are skipped.
+	now := util.GetTimeStamp(time.Now())
+	goroutine := meta.ProfileTarget{Kind: "goroutine", Component: discovery.ComponentTiDB, Address: address}
+	for ts := now; ts <= now+2; ts++ {
+		require.NoError(t, st.AddProfile(goroutine, ts, []byte(testGoroutineProfile), meta.ProfileAttr{}))
+	}
+	results, err := m.Capture(ctx, &CaptureParam{Targets: []meta.ProfileTarget{tidb}, Kinds: []string{"goroutine"}, Note: "high latency"})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, goroutine, results[0].Target)
+	require.Empty(t, results[0].Error)
+	require.GreaterOrEqual(t, results[0].Ts, now+3)
+
+	lists, err := st.QueryProfileList(&meta.BasicQueryParam{Begin: results[0].Ts, End: results[0].Ts, Tag: meta.ProfileTagManual})
+	require.NoError(t, err)
+	require.Len(t, lists, 1)
+	require.Equal(t, []int64{results[0].Ts}, lists[0].TsList)
+	require.Equal(t, meta.ProfileTagManual, lists[0].Attrs[0].Tag)
+	require.Equal(t, "high latency", lists[0].Attrs[0].Note)
+	require.Equal(t, meta.ProfileFormatText, lists[0].Attrs[0].Format)
+}
+
+func TestScrapeCollideWithCapture(t *testing.T) {
+	m, st, address := newTestManager(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	target := NewTarget(discovery.ComponentTiDB, address, "goroutine", "http", &config.PprofProfilingConfig{})
+	spec := scrapeSpec{interval: time.Minute, timeout: time.Second}
+	suite := newScrapeSuite(ctx, target.ProfileTarget, newScraper(target, http.DefaultClient), m.store, spec)
+
+	// the manual captures take the timestamps of the scheduled scrape.
+	now := util.GetTimeStamp(time.Now())
+	for ts := now; ts <= now+2; ts++ {
+		attr := meta.ProfileAttr{Tag: meta.ProfileTagManual}
+		require.NoError(t, st.AddProfile(target.ProfileTarget, ts, []byte(testGoroutineProfile), attr))
+	}
+	suite.runOnce(time.Now(), func() {})
+	status := suite.GetStatus()
+	require.Equal(t, HealthUp, status.Health)
+	require.Empty(t, status.LastError)
+	require.Equal(t, 0, status.ConsecutiveFailures)
+}
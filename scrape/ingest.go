@@ -0,0 +1,127 @@
+package scrape
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/crazycs520/continuous-profile/metrics"
+	"github.com/crazycs520/continuous-profile/util"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidProfile is returned when the pushed profile is invalid.
+var ErrInvalidProfile = errors.New("invalid profile")
+
+const (
+	// maxIngestClockSkew is the max duration the timestamp of the pushed profile can be ahead of now.
+	maxIngestClockSkew = 10 * time.Minute
+	// maxIngestNameLen is the max length of the component and the kind of the pushed profile.
+	maxIngestNameLen = 64
+)
+
+// IngestParam is the param of the pushed profile.
+type IngestParam struct {
+	Target meta.ProfileTarget
+	// Ts is the unix timestamp in seconds of the profile, 0 means now. It should be within the data retention
+	// and not too far in the future.
+	Ts int64
+	// Labels is attached to the pushed profile, and becomes the labels of the target.
+	Labels map[string]string
+	// Build is the build info of the pushing application, it's optional.
+	Build meta.BuildInfo
+	// Instance is the stable identity of the pushing application, it's optional.
+	Instance string
+}
+
+// Ingest validates the pushed profile and stores it with the push tag, the gzip compressed profile is
+// decompressed and the profile larger than maxSize is rejected. It returns the timestamp of the stored profile.
+func (m *Manager) Ingest(param *IngestParam, body io.Reader, maxSize int) (int64, error) {
+	pt := param.Target
+	if err := validateIngestTarget(pt); err != nil {
+		return 0, err
+	}
+	buf := bytes.NewBuffer(nil)
+	err := copyProfile(buf, body, maxSize)
+	if err != nil {
+		return 0, err
+	}
+	format, _, err := classifyProfile(buf.Bytes())
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+	}
+
+	now := time.Now()
+	ts := param.Ts
+	if ts <= 0 {
+		ts = util.GetTimeStamp(now)
+	}
+	retention := time.Duration(config.GetGlobalConfig().ContinueProfiling.DataRetentionSeconds) * time.Second
+	minTs, maxTs := util.GetTimeStamp(now.Add(-retention)), util.GetTimeStamp(now.Add(maxIngestClockSkew))
+	if ts < minTs || ts > maxTs {
+		return 0, fmt.Errorf("ts(%v) should be a unix timestamp in seconds between %v and %v", ts, minTs, maxTs)
+	}
+	attr := meta.ProfileAttr{
+		Format: format,
+		Tag:    meta.ProfileTagPush,
+		Labels: param.Labels,
+	}
+	err = m.store.BindProfileTargetInstance(pt, param.Instance)
+	if err != nil {
+		return 0, err
+	}
+	err = m.store.AddProfile(pt, ts, buf.Bytes(), attr)
+	if err != nil {
+		return 0, err
+	}
+	metrics.IngestBytesCounter.WithLabelValues(pt.Component, pt.Kind).Add(float64(buf.Len()))
+	// the pushed target is not scraped, update its last scrape ts to keep it from being dropped by the gc.
+	// The labels of the latest push are the labels of the target.
+	_, err = m.store.UpdateProfileTargetInfo(pt, ts, param.Labels)
+	if err != nil {
+		return 0, err
+	}
+	_, err = m.store.UpdateProfileTargetBuild(pt, param.Build, ts)
+	if err != nil {
+		return 0, err
+	}
+	return ts, nil
+}
+
+// nameRegexp matches the component and the kind of the pushed profile, they are used in the file names of the
+// downloaded profiles and the metric labels.
+var nameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// hostRegexp matches the host name or the IPv4 address.
+var hostRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func validateIngestTarget(pt meta.ProfileTarget) error {
+	if pt.Component == "" || pt.Address == "" || pt.Kind == "" {
+		return errors.New("component, address and kind should not be empty")
+	}
+	if !nameRegexp.MatchString(pt.Component) || len(pt.Component) > maxIngestNameLen {
+		return fmt.Errorf("component(%v) should only contain letters, digits, `_` and `-`, and at most %v characters",
+			pt.Component, maxIngestNameLen)
+	}
+	if !nameRegexp.MatchString(pt.Kind) || len(pt.Kind) > maxIngestNameLen {
+		return fmt.Errorf("kind(%v) should only contain letters, digits, `_` and `-`, and at most %v characters",
+			pt.Kind, maxIngestNameLen)
+	}
+	host, port, err := net.SplitHostPort(pt.Address)
+	if err != nil {
+		return fmt.Errorf("address(%v) should be host:port: %v", pt.Address, err)
+	}
+	if net.ParseIP(host) == nil && !hostRegexp.MatchString(host) {
+		return fmt.Errorf("the host of address(%v) is invalid", pt.Address)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("the port of address(%v) is invalid", pt.Address)
+	}
+	return nil
+}
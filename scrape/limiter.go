@@ -0,0 +1,124 @@
+package scrape
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/crazycs520/continuous-profile/meta"
+)
+
+// scrapeLimiter limits the number of the running scrapes, and the number of the running CPU-heavy
+// scrapes on the same host. The limits can be changed at runtime, 0 means no limit.
+type scrapeLimiter struct {
+	mu            sync.Mutex
+	maxConcurrent int
+	maxHostHeavy  int
+	running       int
+	hostHeavy     map[string]int
+	// changed is closed and replaced when a scrape finished or the limits changed.
+	changed chan struct{}
+}
+
+func newScrapeLimiter(maxConcurrent, maxHostHeavy int) *scrapeLimiter {
+	return &scrapeLimiter{
+		maxConcurrent: maxConcurrent,
+		maxHostHeavy:  maxHostHeavy,
+		hostHeavy:     make(map[string]int),
+		changed:       make(chan struct{}),
+	}
+}
+
+// setLimits updates the limits, the waiting scrapes are woken up to check the new limits.
+func (l *scrapeLimiter) setLimits(maxConcurrent, maxHostHeavy int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxConcurrent == maxConcurrent && l.maxHostHeavy == maxHostHeavy {
+		return
+	}
+	l.maxConcurrent = maxConcurrent
+	l.maxHostHeavy = maxHostHeavy
+	l.notifyLocked()
+}
+
+// acquire blocks until the scrape of the target is allowed to run, the returned function must be
+// called after the scrape finished.
+func (l *scrapeLimiter) acquire(ctx context.Context, target meta.ProfileTarget) (func(), error) {
+	for {
+		// get the channel before checking the limits, so the change after the check is not missed.
+		changed := l.changedChan()
+		if release, ok := l.tryAcquire(target); ok {
+			return release, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// tryAcquire acquires the limiter for the scrape of the target without blocking, the returned function must be
+// called after the scrape finished if it's allowed.
+func (l *scrapeLimiter) tryAcquire(target meta.ProfileTarget) (func(), bool) {
+	host := getHost(target.Address)
+	heavy := isHeavyKind(target.Kind)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.allowLocked(host, heavy) {
+		return nil, false
+	}
+	l.running++
+	if heavy {
+		l.hostHeavy[host]++
+	}
+	return func() { l.release(host, heavy) }, true
+}
+
+// changedChan returns the channel which is closed when a scrape finished or the limits changed.
+func (l *scrapeLimiter) changedChan() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.changed
+}
+
+func (l *scrapeLimiter) allowLocked(host string, heavy bool) bool {
+	if l.maxConcurrent > 0 && l.running >= l.maxConcurrent {
+		return false
+	}
+	if heavy && l.maxHostHeavy > 0 && l.hostHeavy[host] >= l.maxHostHeavy {
+		return false
+	}
+	return true
+}
+
+func (l *scrapeLimiter) release(host string, heavy bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running--
+	if heavy {
+		l.hostHeavy[host]--
+		if l.hostHeavy[host] <= 0 {
+			delete(l.hostHeavy, host)
+		}
+	}
+	l.notifyLocked()
+}
+
+func (l *scrapeLimiter) notifyLocked() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// isHeavyKind returns true if the profile kind takes the CPU of the target during the profile seconds.
+func isHeavyKind(kind string) bool {
+	return kind == meta.ProfileKindProfile || kind == meta.ProfileKindTrace
+}
+
+func getHost(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
@@ -0,0 +1,63 @@
+package scrape
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeLimiter(t *testing.T) {
+	l := newScrapeLimiter(3, 1)
+	ctx := context.Background()
+	tidbProfile := meta.ProfileTarget{Kind: "profile", Component: "tidb", Address: "10.0.1.1:10080"}
+	tikvProfile := meta.ProfileTarget{Kind: "profile", Component: "tikv", Address: "10.0.1.1:20180"}
+	pdProfile := meta.ProfileTarget{Kind: "profile", Component: "pd", Address: "10.0.1.2:2379"}
+	tidbHeap := meta.ProfileTarget{Kind: "heap", Component: "tidb", Address: "10.0.1.1:10080"}
+
+	release1, err := l.acquire(ctx, tidbProfile)
+	require.NoError(t, err)
+	// the heavy scrape on the same host should wait.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	_, err = l.acquire(timeoutCtx, tikvProfile)
+	cancel()
+	require.Equal(t, context.DeadlineExceeded, err)
+	// the heavy scrape on other host and the light scrape on the same host are allowed.
+	release2, err := l.acquire(ctx, pdProfile)
+	require.NoError(t, err)
+	release3, err := l.acquire(ctx, tidbHeap)
+	require.NoError(t, err)
+
+	acquired := make(chan func(), 1)
+	go func() {
+		release, err := l.acquire(ctx, tikvProfile)
+		require.NoError(t, err)
+		acquired <- release
+	}()
+	// the global limit is reached, releasing the light scrape is not enough for the heavy scrape on the same host.
+	release3()
+	select {
+	case <-acquired:
+		t.Fatal("the heavy scrape on the same host should wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release1()
+	release4 := <-acquired
+
+	// the new limits take effect on the waiting scrapes.
+	go func() {
+		release, err := l.acquire(ctx, tidbProfile)
+		require.NoError(t, err)
+		acquired <- release
+	}()
+	l.setLimits(3, 2)
+	release5 := <-acquired
+
+	release2()
+	release4()
+	release5()
+	require.Equal(t, 0, l.running)
+	require.Len(t, l.hostHeavy, 0)
+}
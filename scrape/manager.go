@@ -3,8 +3,8 @@ package scrape
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"sort"
-	"strconv"
 	"sync"
 	"time"
 
@@ -14,41 +14,61 @@ import (
 	"github.com/crazycs520/continuous-profile/store"
 	"github.com/crazycs520/continuous-profile/util"
 	"github.com/pingcap/log"
-	commonconfig "github.com/prometheus/common/config"
 	"go.uber.org/zap"
 )
 
 // Manager maintains a set of scrape pools and manages start/stop cycles
 // when receiving new target groups form the discovery manager.
 type Manager struct {
-	store          *store.ProfileStorage
-	topoSubScribe  discovery.Subscriber
-	reloadCh       chan struct{}
-	curComponents  map[discovery.Component]struct{}
-	lastComponents map[discovery.Component]struct{}
+	store         *store.ProfileStorage
+	topoSubScribe discovery.Subscriber
+	reloadCh      chan struct{}
+	curComponents map[discovery.ComponentKey]discovery.Component
+	// lastComponents is the latest components, it's only updated by the run goroutine and protected by mu.
+	lastComponents map[discovery.ComponentKey]discovery.Component
+	// staticJobs contains the components which declared in the scrape_configs of config file.
+	staticJobs map[discovery.ComponentKey]*staticJob
+	// limiter is shared by all the scrape suites and the capture.
+	limiter *scrapeLimiter
+	// builds is the build info fetched from the status endpoints of the components.
+	builds *buildFetcher
 
-	cancel context.CancelFunc
-	wg     sync.WaitGroup
+	cancel    context.CancelFunc
+	scheduler *scheduler
 
 	mu           sync.Mutex
 	scrapeSuites map[meta.ProfileTarget]*ScrapeSuite
+	// bursts is the burst windows which override the scrape interval, it's protected by mu.
+	bursts  []*Burst
+	burstID int64
+	// alignedHeavyConflict is true if the aligned heavy scrapes on the same host are serialized by the limiter,
+	// it's used to warn only once. It's only accessed by the run goroutine.
+	alignedHeavyConflict bool
 }
 
 // NewManager is the Manager constructor
 func NewManager(store *store.ProfileStorage, topoSubScribe discovery.Subscriber) *Manager {
-	return &Manager{
-		store:          store,
-		topoSubScribe:  topoSubScribe,
-		reloadCh:       make(chan struct{}, 10),
-		curComponents:  map[discovery.Component]struct{}{},
-		lastComponents: map[discovery.Component]struct{}{},
-		scrapeSuites:   make(map[meta.ProfileTarget]*ScrapeSuite),
+	cfg := config.GetGlobalConfig()
+	limiter := newScrapeLimiter(cfg.ContinueProfiling.MaxConcurrentScrapes, cfg.ContinueProfiling.MaxHostHeavyScrapes)
+	m := &Manager{
+		store:         store,
+		topoSubScribe: topoSubScribe,
+		reloadCh:      make(chan struct{}, 10),
+		curComponents: map[discovery.ComponentKey]discovery.Component{},
+		staticJobs:    buildStaticJobs(cfg.ScrapeConfigs),
+		limiter:       limiter,
+		builds:        newBuildFetcher(),
+		scheduler:     newScheduler(cfg.ContinueProfiling.ScrapeWorkers, limiter),
+		scrapeSuites:  make(map[meta.ProfileTarget]*ScrapeSuite),
 	}
+	m.lastComponents = m.buildComponentMap(nil)
+	return m
 }
 
 func (m *Manager) Start() {
 	ctx, cancel := context.WithCancel(context.Background())
 	m.cancel = cancel
+	m.scheduler.start(ctx)
 	go util.GoWithRecovery(func() {
 		m.run(ctx)
 	}, nil)
@@ -56,6 +76,11 @@ func (m *Manager) Start() {
 	go util.GoWithRecovery(func() {
 		m.updateTargetMetaLoop(ctx)
 	}, nil)
+
+	// start the static scrape jobs without waiting for the topology discovery.
+	if len(m.staticJobs) > 0 {
+		m.NotifyReload()
+	}
 }
 
 func (m *Manager) NotifyReload() {
@@ -66,10 +91,12 @@ func (m *Manager) NotifyReload() {
 }
 
 func (m *Manager) GetCurrentScrapeComponents() []discovery.Component {
+	m.mu.Lock()
 	components := make([]discovery.Component, 0, len(m.curComponents))
-	for comp := range m.curComponents {
+	for _, comp := range m.curComponents {
 		components = append(components, comp)
 	}
+	m.mu.Unlock()
 	sort.Slice(components, func(i, j int) bool {
 		if components[i].Name != components[j].Name {
 			return components[i].Name < components[j].Name
@@ -89,6 +116,13 @@ func (m *Manager) updateTargetMetaLoop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
+			m.mu.Lock()
+			components := make([]discovery.Component, 0, len(m.lastComponents))
+			for _, comp := range m.lastComponents {
+				components = append(components, comp)
+			}
+			m.mu.Unlock()
+			m.builds.refresh(ctx, components)
 			m.updateTargetMeta()
 		}
 	}
@@ -98,12 +132,16 @@ func (m *Manager) updateTargetMeta() {
 	targets, suites := m.GetAllCurrentScrapeSuite()
 	count := 0
 	for i, suite := range suites {
-		ts := util.GetTimeStamp(suite.lastScrape)
+		lastSuccess := suite.GetStatus().LastSuccess
+		if lastSuccess.IsZero() {
+			continue
+		}
+		ts := util.GetTimeStamp(lastSuccess)
 		if ts <= 0 {
 			continue
 		}
 		target := targets[i]
-		updated, err := m.store.UpdateProfileTargetInfo(target, ts)
+		updated, err := suite.syncTargetInfo(ts)
 		if err != nil {
 			log.Error("update profile target info failed",
 				zap.String("component", target.Component),
@@ -118,127 +156,239 @@ func (m *Manager) updateTargetMeta() {
 }
 
 func (m *Manager) run(ctx context.Context) {
-	buildMap := func(components []discovery.Component) map[discovery.Component]struct{} {
-		m := make(map[discovery.Component]struct{}, len(components))
-		for _, comp := range components {
-			m[comp] = struct{}{}
-		}
-		return m
-	}
-	oldCfg := config.GetGlobalConfig().ContinueProfiling
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case components := <-m.topoSubScribe:
-			m.lastComponents = buildMap(components)
+			compMap := m.buildComponentMap(components)
+			m.mu.Lock()
+			m.lastComponents = compMap
+			m.mu.Unlock()
 		case <-m.reloadCh:
 			break
 		}
 
-		newCfg := config.GetGlobalConfig().ContinueProfiling
-		m.reload(ctx, oldCfg, newCfg)
-		oldCfg = newCfg
+		m.reload(ctx, config.GetGlobalConfig().ContinueProfiling)
 	}
 }
 
-func (m *Manager) reload(ctx context.Context, oldCfg, newCfg config.ContinueProfilingConfig) {
-	configChanged := oldCfg != newCfg
-	// close for old components
-	for comp := range m.curComponents {
-		_, exist := m.lastComponents[comp]
-		if exist && !configChanged {
-			continue
+// buildComponentMap merges the discovered components and the static scrape job components. If a static
+// job component is also discovered at the same status address, they are merged into one component, the
+// labels of the static job override the discovered labels, and the interval and profiling_config of the
+// static job are used, see buildComponentSpecs.
+func (m *Manager) buildComponentMap(components []discovery.Component) map[discovery.ComponentKey]discovery.Component {
+	compMap := make(map[discovery.ComponentKey]discovery.Component, len(components)+len(m.staticJobs))
+	for _, comp := range components {
+		compMap[comp.Key()] = comp
+	}
+	for key, job := range m.staticJobs {
+		comp := job.component
+		if discovered, ok := compMap[key]; ok {
+			comp = discovered
+			comp.Labels = mergeLabels(discovered.Labels, job.component.Labels)
 		}
-		m.stopScrape(comp)
+		compMap[key] = comp
 	}
+	return compMap
+}
 
-	// close for old components
-	if !newCfg.Enable {
-		return
+// mergeLabels returns the union of the labels, the latter labels override the former.
+func mergeLabels(labels ...map[string]string) map[string]string {
+	var merged map[string]string
+	for _, ls := range labels {
+		for k, v := range ls {
+			if merged == nil {
+				merged = make(map[string]string)
+			}
+			merged[k] = v
+		}
 	}
+	return merged
+}
+
+// scrapeSpec describes what a ScrapeSuite scrapes, the suite only needs to restart when its spec changed.
+type scrapeSpec struct {
+	component   discovery.Component
+	pprofConfig config.PprofProfilingConfig
+	interval    time.Duration
+	timeout     time.Duration
+	// aligned means the scrape starts at the interval boundary, see config.ContinueProfilingConfig.Aligned.
+	aligned bool
+}
+
+func (m *Manager) reload(ctx context.Context, continueProfilingCfg config.ContinueProfilingConfig) {
+	m.limiter.setLimits(continueProfilingCfg.MaxConcurrentScrapes, continueProfilingCfg.MaxHostHeavyScrapes)
+	specs := m.buildScrapeSpecs(continueProfilingCfg)
+	m.checkAlignedHeavyConflict(continueProfilingCfg, specs)
 
-	//start for new component.
-	for comp := range m.lastComponents {
-		_, exist := m.curComponents[comp]
-		if exist && !configChanged {
+	// stop the suites which are removed or changed.
+	targets, suites := m.GetAllCurrentScrapeSuite()
+	for i, target := range targets {
+		spec, ok := specs[target]
+		if ok && reflect.DeepEqual(*spec, suites[i].spec) {
 			continue
 		}
-		err := m.startScrape(ctx, comp, newCfg)
-		if err != nil {
-			log.Error("start scrape failed",
-				zap.String("component", comp.Name),
-				zap.String("address", comp.IP+":"+strconv.Itoa(int(comp.StatusPort))))
+		m.stopScrape(target)
+	}
+
+	// start the suites which are new or changed.
+	components := make(map[discovery.ComponentKey]discovery.Component, len(m.lastComponents))
+	for target, spec := range specs {
+		if m.getScrapeSuite(target) == nil {
+			err := m.startScrape(ctx, target, *spec)
+			if err != nil {
+				log.Error("start scrape failed",
+					zap.String("component", target.Component),
+					zap.String("address", target.Address),
+					zap.String("kind", target.Kind),
+					zap.Error(err))
+				continue
+			}
 		}
+		components[spec.component.Key()] = spec.component
+	}
+	m.mu.Lock()
+	m.curComponents = components
+	m.mu.Unlock()
+}
+
+// checkAlignedHeavyConflict warns if the aligned heavy scrapes on the same host exceed max_host_heavy_scrapes. The
+// limiter serializes them, so they are tagged with the same round but don't cover the same window.
+func (m *Manager) checkAlignedHeavyConflict(continueProfilingCfg config.ContinueProfilingConfig, specs map[meta.ProfileTarget]*scrapeSpec) {
+	hosts := getAlignedHeavyConflictHosts(specs, continueProfilingCfg.MaxHostHeavyScrapes)
+	conflict := len(hosts) > 0
+	if conflict && !m.alignedHeavyConflict {
+		log.Warn("the aligned CPU-heavy scrapes on the same host are serialized by max_host_heavy_scrapes, "+
+			"they don't cover the same window, set max_host_heavy_scrapes to 0 to run them at the same time",
+			zap.Int("max-host-heavy-scrapes", continueProfilingCfg.MaxHostHeavyScrapes),
+			zap.Strings("hosts", hosts))
 	}
+	m.alignedHeavyConflict = conflict
 }
 
-func (m *Manager) startScrape(ctx context.Context, component discovery.Component, continueProfilingCfg config.ContinueProfilingConfig) error {
-	if !continueProfilingCfg.Enable {
+// getAlignedHeavyConflictHosts returns the hosts which have more aligned heavy scrapes than maxHostHeavy.
+func getAlignedHeavyConflictHosts(specs map[meta.ProfileTarget]*scrapeSpec, maxHostHeavy int) []string {
+	if maxHostHeavy <= 0 {
 		return nil
 	}
-	profilingConfig := m.getProfilingConfig(component)
-	cfg := config.GetGlobalConfig()
-	httpCfg := cfg.Security.GetHTTPClientConfig()
-	addr := fmt.Sprintf("%v:%v", component.IP, component.StatusPort)
-	for profileName, profileConfig := range profilingConfig.PprofConfig {
-		target := NewTarget(component.Name, addr, profileName, cfg.GetHTTPScheme(), profileConfig)
-		client, err := commonconfig.NewClientFromConfig(httpCfg, component.Name)
-		if err != nil {
-			return err
+	counts := make(map[string]int)
+	for target, spec := range specs {
+		if spec.aligned && isHeavyKind(target.Kind) {
+			counts[getHost(target.Address)]++
 		}
-		scrape := newScraper(target, client)
-		scrapeSuite := newScrapeSuite(ctx, scrape, m.store)
-		pt := meta.ProfileTarget{
-			Kind:      profileName,
-			Component: component.Name,
-			Address:   addr,
+	}
+	hosts := make([]string, 0)
+	for host, count := range counts {
+		if count > maxHostHeavy {
+			hosts = append(hosts, host)
 		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
 
-		interval := time.Duration(continueProfilingCfg.IntervalSeconds) * time.Second
-		timeout := time.Duration(continueProfilingCfg.TimeoutSeconds) * time.Second
-		m.wg.Add(1)
-		go util.GoWithRecovery(func() {
-			defer m.wg.Done()
-			scrapeSuite.run(interval, timeout)
-		}, nil)
-		m.addScrapeSuite(pt, scrapeSuite)
+func (m *Manager) buildScrapeSpecs(continueProfilingCfg config.ContinueProfilingConfig) map[meta.ProfileTarget]*scrapeSpec {
+	bursts := m.GetActiveBursts()
+	specs := make(map[meta.ProfileTarget]*scrapeSpec)
+	for _, comp := range m.lastComponents {
+		enabled := continueProfilingCfg.ForComponent(comp.Name).Enable
+		for target, spec := range m.buildComponentSpecs(comp, continueProfilingCfg) {
+			if !enabled || !spec.pprofConfig.IsEnabled() {
+				continue
+			}
+			if interval, ok := getBurstInterval(bursts, target); ok {
+				spec.interval = interval
+			}
+			specs[target] = spec
+		}
 	}
-	m.curComponents[component] = struct{}{}
-	log.Info("start component scrape",
-		zap.String("component", component.Name),
-		zap.String("address", addr))
-	return nil
+	return specs
 }
 
-func (m *Manager) stopScrape(component discovery.Component) {
-	delete(m.curComponents, component)
-	addr := fmt.Sprintf("%v:%v", component.IP, component.StatusPort)
-	log.Info("stop component scrape",
-		zap.String("component", component.Name),
-		zap.String("address", addr))
-	profilingConfig := m.getProfilingConfig(component)
-	for profileName := range profilingConfig.PprofConfig {
-		key := meta.ProfileTarget{
-			Kind:      profileName,
-			Component: component.Name,
-			Address:   addr,
+// buildComponentSpecs returns the scrape specs of all the profile kinds of the component, including the disabled ones.
+func (m *Manager) buildComponentSpecs(comp discovery.Component, continueProfilingCfg config.ContinueProfilingConfig) map[meta.ProfileTarget]*scrapeSpec {
+	cfg := continueProfilingCfg.ForComponent(comp.Name)
+	interval := time.Duration(cfg.IntervalSeconds) * time.Second
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	pprofConfig := continueProfilingCfg.GetPprofConfig(comp.Name)
+	if job := m.staticJobs[comp.Key()]; job != nil {
+		if job.cfg.ScrapeInterval > 0 {
+			interval = job.cfg.ScrapeInterval
+		}
+		if job.cfg.ScrapeTimeout > 0 {
+			timeout = job.cfg.ScrapeTimeout
+		}
+		if job.cfg.ProfilingConfig != nil {
+			pprofConfig = job.cfg.ProfilingConfig.PprofConfig
 		}
-		suite := m.deleteScrapeSuite(key)
-		if suite == nil {
+	}
+	specs := make(map[meta.ProfileTarget]*scrapeSpec, len(pprofConfig))
+	addr := fmt.Sprintf("%v:%v", comp.IP, comp.StatusPort)
+	for kind, pc := range pprofConfig {
+		if pc == nil {
 			continue
 		}
-		suite.stop()
+		target := meta.ProfileTarget{
+			Kind:      kind,
+			Component: comp.Name,
+			Address:   addr,
+		}
+		spec := &scrapeSpec{
+			component:   comp,
+			pprofConfig: *pc,
+			interval:    interval,
+			timeout:     timeout,
+			aligned:     cfg.Aligned,
+		}
+		if pc.IntervalSeconds > 0 {
+			spec.interval = time.Duration(pc.IntervalSeconds) * time.Second
+		}
+		if pc.TimeoutSeconds > 0 {
+			spec.timeout = time.Duration(pc.TimeoutSeconds) * time.Second
+		}
+		if pc.MaxSize <= 0 {
+			spec.pprofConfig.MaxSize = cfg.MaxProfileSize
+		}
+		specs[target] = spec
 	}
+	return specs
 }
 
-func (m *Manager) getProfilingConfig(component discovery.Component) *config.ProfilingConfig {
-	switch component.Name {
-	case discovery.ComponentTiDB, discovery.ComponentPD:
-		return goAppProfilingConfig()
-	default:
-		return nonGoAppProfilingConfig()
+func (m *Manager) startScrape(ctx context.Context, target meta.ProfileTarget, spec scrapeSpec) error {
+	source, err := newProfileSource(target, &spec.pprofConfig)
+	if err != nil {
+		return err
+	}
+	scrapeSuite := newScrapeSuite(ctx, target, source, m.store, spec)
+	scrapeSuite.builds = m.builds
+	m.addScrapeSuite(target, scrapeSuite)
+	m.scheduler.add(scrapeSuite, scrapeSuite.firstRunTime())
+	log.Info("start scrape",
+		zap.String("component", target.Component),
+		zap.String("address", target.Address),
+		zap.String("kind", target.Kind),
+		zap.Duration("interval", spec.interval))
+	return nil
+}
+
+func (m *Manager) stopScrape(target meta.ProfileTarget) {
+	suite := m.deleteScrapeSuite(target)
+	if suite == nil {
+		return
 	}
+	m.scheduler.remove(suite)
+	suite.stop()
+	log.Info("stop scrape",
+		zap.String("component", target.Component),
+		zap.String("address", target.Address),
+		zap.String("kind", target.Kind))
+}
+
+func (m *Manager) getScrapeSuite(pt meta.ProfileTarget) *ScrapeSuite {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.scrapeSuites[pt]
 }
 
 func (m *Manager) addScrapeSuite(pt meta.ProfileTarget, suite *ScrapeSuite) {
@@ -269,49 +419,55 @@ func (m *Manager) GetAllCurrentScrapeSuite() ([]meta.ProfileTarget, []*ScrapeSui
 	return targets, suites
 }
 
+// GetScrapeStatuses returns the scrape status of all the current targets.
+func (m *Manager) GetScrapeStatuses() []ScrapeStatus {
+	_, suites := m.GetAllCurrentScrapeSuite()
+	statuses := make([]ScrapeStatus, 0, len(suites))
+	for _, suite := range suites {
+		statuses = append(statuses, suite.GetStatus())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		ti, tj := statuses[i].Target, statuses[j].Target
+		if ti.Component != tj.Component {
+			return ti.Component < tj.Component
+		}
+		if ti.Address != tj.Address {
+			return ti.Address < tj.Address
+		}
+		return ti.Kind < tj.Kind
+	})
+	return statuses
+}
+
 func (m *Manager) Close() error {
 	if m.cancel != nil {
 		m.cancel()
+		m.scheduler.wait()
 	}
-	err := m.store.Close()
-	if err != nil {
-		return err
-	}
-	m.wg.Wait()
-	return nil
+	return m.store.Close()
 }
 
-func goAppProfilingConfig() *config.ProfilingConfig {
-	cfg := config.GetGlobalConfig().ContinueProfiling
-	return &config.ProfilingConfig{
-		PprofConfig: config.PprofConfig{
-			"allocs": &config.PprofProfilingConfig{
-				Path: "/debug/pprof/allocs",
-			},
-			"goroutine": &config.PprofProfilingConfig{
-				Path:   "/debug/pprof/goroutine",
-				Params: map[string]string{"debug": "2"},
-			},
-			"mutex": &config.PprofProfilingConfig{
-				Path: "/debug/pprof/mutex",
-			},
-			"profile": &config.PprofProfilingConfig{
-				Path:    "/debug/pprof/profile",
-				Seconds: cfg.ProfileSeconds,
-			},
-		},
-	}
+// staticJob is a component declared in the scrape_configs of config file.
+type staticJob struct {
+	component discovery.Component
+	cfg       *config.ScrapeConfig
 }
 
-func nonGoAppProfilingConfig() *config.ProfilingConfig {
-	cfg := config.GetGlobalConfig().ContinueProfiling
-	return &config.ProfilingConfig{
-		PprofConfig: config.PprofConfig{
-			"profile": &config.PprofProfilingConfig{
-				Path:    "/debug/pprof/profile",
-				Seconds: cfg.ProfileSeconds,
-				Header:  map[string]string{"Content-Type": "application/protobuf"},
-			},
-		},
+func buildStaticJobs(scrapeConfigs []*config.ScrapeConfig) map[discovery.ComponentKey]*staticJob {
+	jobs := make(map[discovery.ComponentKey]*staticJob)
+	for _, job := range scrapeConfigs {
+		for _, target := range job.Targets {
+			comp, err := discovery.NewComponent(job.ComponentName, target, job.Labels)
+			if err != nil {
+				log.Error("invalid scrape target",
+					zap.String("component", job.ComponentName),
+					zap.String("target", target),
+					zap.Error(err))
+				continue
+			}
+			comp.Source = discovery.SourceScrapeConfig
+			jobs[comp.Key()] = &staticJob{component: comp, cfg: job}
+		}
 	}
+	return jobs
 }
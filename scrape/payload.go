@@ -0,0 +1,63 @@
+package scrape
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/google/pprof/profile"
+)
+
+var (
+	traceMagicPrefix    = []byte("go 1.")
+	traceMagicSuffix    = []byte(" trace\x00")
+	goroutineTextPrefix = [][]byte{[]byte("goroutine "), []byte("goroutine profile: total ")}
+	jemallocHeapPrefix  = []byte("heap_v2/")
+)
+
+// classifyProfile validates the scraped payload and returns its format. The parsed profile is returned
+// if the payload is a pprof protobuf.
+func classifyProfile(data []byte) (string, *profile.Profile, error) {
+	if len(data) == 0 {
+		return "", nil, errors.New("empty profile")
+	}
+	if isTrace(data) {
+		return meta.ProfileFormatTrace, nil, nil
+	}
+	for _, prefix := range goroutineTextPrefix {
+		if bytes.HasPrefix(data, prefix) {
+			return meta.ProfileFormatText, nil, nil
+		}
+	}
+	if bytes.HasPrefix(data, jemallocHeapPrefix) {
+		return meta.ProfileFormatJemalloc, nil, nil
+	}
+	p, err := profile.ParseData(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("unknown profile format: %v", err)
+	}
+	return meta.ProfileFormatProtobuf, p, nil
+}
+
+// isTrace checks the go execution trace header, such as "go 1.17 trace\x00\x00\x00".
+func isTrace(data []byte) bool {
+	const headerLen = 16
+	if len(data) < headerLen || !bytes.HasPrefix(data, traceMagicPrefix) {
+		return false
+	}
+	return bytes.Contains(data[:headerLen], traceMagicSuffix)
+}
+
+// detectFormat returns the format of the profile. The profile is not validated if the format is declared
+// in the config, such as the perf.data collected by the exec source.
+func detectFormat(data []byte, pc *config.PprofProfilingConfig) (string, *profile.Profile, error) {
+	if pc.Format != "" {
+		if len(data) == 0 {
+			return "", nil, errors.New("empty profile")
+		}
+		return pc.Format, nil, nil
+	}
+	return classifyProfile(data)
+}
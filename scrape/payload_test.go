@@ -0,0 +1,65 @@
+package scrape
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func mockProfileData(t *testing.T, samples int) []byte {
+	fn := &profile.Function{ID: 1, Name: "main.main"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Function:   []*profile.Function{fn},
+		Location:   []*profile.Location{loc},
+	}
+	for i := 0; i < samples; i++ {
+		p.Sample = append(p.Sample, &profile.Sample{Location: []*profile.Location{loc}, Value: []int64{1}})
+	}
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, p.Write(buf))
+	return buf.Bytes()
+}
+
+func TestClassifyProfile(t *testing.T) {
+	data := mockProfileData(t, 2)
+	format, p, err := classifyProfile(data)
+	require.NoError(t, err)
+	require.Equal(t, meta.ProfileFormatProtobuf, format)
+	require.Len(t, p.Sample, 2)
+
+	format, p, err = classifyProfile(mockProfileData(t, 0))
+	require.NoError(t, err)
+	require.Equal(t, meta.ProfileFormatProtobuf, format)
+	require.Len(t, p.Sample, 0)
+
+	cases := []struct {
+		data   string
+		format string
+	}{
+		{"goroutine 1 [running]:\nmain.main()\n", meta.ProfileFormatText},
+		{"goroutine profile: total 1\n1 @ 0x1\n", meta.ProfileFormatText},
+		{"go 1.17 trace\x00\x00\x00\x00", meta.ProfileFormatTrace},
+		{"heap_v2/524288\n  t*: 1: 2 [0: 0]\n", meta.ProfileFormatJemalloc},
+	}
+	for _, c := range cases {
+		format, p, err = classifyProfile([]byte(c.data))
+		require.NoError(t, err)
+		require.Equal(t, c.format, format)
+		require.Nil(t, p)
+	}
+
+	invalids := [][]byte{
+		nil,
+		[]byte("<html><body>502 Bad Gateway</body></html>"),
+		data[:len(data)/2],
+	}
+	for _, invalid := range invalids {
+		_, _, err = classifyProfile(invalid)
+		require.Error(t, err)
+	}
+}
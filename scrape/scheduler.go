@@ -0,0 +1,278 @@
+package scrape
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/metrics"
+	"github.com/crazycs520/continuous-profile/util"
+)
+
+// scheduledJob is a scrape suite waiting in the queue of the scheduler.
+type scheduledJob struct {
+	suite *ScrapeSuite
+	next  time.Time
+	// index is the index in the queue, -1 means the job is running or removed.
+	index   int
+	removed bool
+	// release releases the limiter acquired for the run, it's set when the job is dispatched.
+	release func()
+}
+
+// jobQueue is a priority queue of the jobs ordered by the next run time.
+type jobQueue []*scheduledJob
+
+func (q jobQueue) Len() int { return len(q) }
+
+func (q jobQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *jobQueue) Push(x interface{}) {
+	job := x.(*scheduledJob)
+	job.index = len(*q)
+	*q = append(*q, job)
+}
+
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	job := old[n-1]
+	old[n-1] = nil
+	job.index = -1
+	*q = old[:n-1]
+	return job
+}
+
+// scheduler runs the due scrape suites by a fixed worker pool. A job is in the queue at most once, the
+// overdue runs of a job are coalesced into one run instead of piling up. A due job is dispatched only after
+// the limiter allows it, so the jobs blocked by the limiter don't occupy the workers, and the other due jobs
+// are dispatched before them.
+type scheduler struct {
+	workers int
+	limiter *scrapeLimiter
+
+	mu    sync.Mutex
+	queue jobQueue
+	jobs  map[*ScrapeSuite]*scheduledJob
+
+	wakeup chan struct{}
+	idle   chan struct{}
+	workCh chan *scheduledJob
+	wg     sync.WaitGroup
+}
+
+func newScheduler(workers int, limiter *scrapeLimiter) *scheduler {
+	return &scheduler{
+		workers: workers,
+		limiter: limiter,
+		jobs:    make(map[*ScrapeSuite]*scheduledJob),
+		wakeup:  make(chan struct{}, 1),
+		idle:    make(chan struct{}),
+		workCh:  make(chan *scheduledJob),
+	}
+}
+
+func (s *scheduler) start(ctx context.Context) {
+	for i := 0; i < s.workers; i++ {
+		s.wg.Add(1)
+		go util.GoWithRecovery(func() {
+			defer s.wg.Done()
+			s.work(ctx)
+		}, nil)
+	}
+	s.wg.Add(1)
+	go util.GoWithRecovery(func() {
+		defer s.wg.Done()
+		s.dispatch(ctx)
+	}, nil)
+}
+
+// wait waits for the dispatcher and the workers to exit after the context is canceled.
+func (s *scheduler) wait() {
+	s.wg.Wait()
+}
+
+// add schedules the suite to run at the next time.
+func (s *scheduler) add(suite *ScrapeSuite, next time.Time) {
+	s.mu.Lock()
+	job := &scheduledJob{suite: suite, next: next}
+	s.jobs[suite] = job
+	heap.Push(&s.queue, job)
+	s.mu.Unlock()
+	s.notify()
+}
+
+// remove removes the suite from the scheduler, the running scrape of the suite is not interrupted.
+func (s *scheduler) remove(suite *ScrapeSuite) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.jobs[suite]
+	if job == nil {
+		return
+	}
+	delete(s.jobs, suite)
+	job.removed = true
+	if job.index >= 0 {
+		heap.Remove(&s.queue, job.index)
+	}
+}
+
+// queueDepth returns the number of the due jobs which are waiting for a worker or the limiter.
+func (s *scheduler) queueDepth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queueDepthLocked(time.Now())
+}
+
+func (s *scheduler) queueDepthLocked(now time.Time) int {
+	depth := 0
+	for _, job := range s.queue {
+		if !job.next.After(now) {
+			depth++
+		}
+	}
+	return depth
+}
+
+func (s *scheduler) notify() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (s *scheduler) dispatch(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		// wait for an idle worker first, so the limiter is only acquired when the job can run right now.
+		select {
+		case <-s.idle:
+		case <-ctx.Done():
+			return
+		}
+		job := s.nextJob(ctx, timer)
+		if job == nil {
+			return
+		}
+		select {
+		case s.workCh <- job:
+		case <-ctx.Done():
+			job.release()
+			return
+		}
+	}
+}
+
+// nextJob waits for the earliest due job which is allowed by the limiter, it returns nil if ctx is done.
+func (s *scheduler) nextJob(ctx context.Context, timer *time.Timer) *scheduledJob {
+	for {
+		// get the channel before checking the limits, so the change after the check is not missed.
+		limitChanged := s.limiter.changedChan()
+		s.mu.Lock()
+		now := time.Now()
+		metrics.ScrapeQueueDepth.Set(float64(s.queueDepthLocked(now)))
+		job, wait := s.popAllowedLocked(now)
+		s.mu.Unlock()
+		if job != nil {
+			return job
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wakeup:
+		case <-limitChanged:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// popAllowedLocked pops the earliest due job which is allowed by the limiter and acquires the limiter for it.
+// Otherwise it returns the duration to wait for the next job which is not due yet.
+func (s *scheduler) popAllowedLocked(now time.Time) (*scheduledJob, time.Duration) {
+	wait := time.Hour
+	var due []*scheduledJob
+	for _, job := range s.queue {
+		if job.next.After(now) {
+			if d := job.next.Sub(now); d < wait {
+				wait = d
+			}
+			continue
+		}
+		due = append(due, job)
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].next.Before(due[j].next) })
+	for _, job := range due {
+		release := func() {}
+		// the probe of the open circuit is cheap, it's not limited.
+		if !job.suite.backoff.open {
+			var ok bool
+			release, ok = s.limiter.tryAcquire(job.suite.target)
+			if !ok {
+				continue
+			}
+		}
+		heap.Remove(&s.queue, job.index)
+		job.release = release
+		metrics.ScrapeWaitDuration.WithLabelValues(job.suite.target.Component, job.suite.target.Kind).
+			Observe(now.Sub(job.next).Seconds())
+		return job, 0
+	}
+	return nil, wait
+}
+
+func (s *scheduler) work(ctx context.Context) {
+	for {
+		select {
+		case s.idle <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case job := <-s.workCh:
+			s.run(job)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *scheduler) run(job *scheduledJob) {
+	suite := job.suite
+	if suite.ctx.Err() != nil {
+		job.release()
+		return
+	}
+	target := suite.target
+	// the runs missed while the job was waiting in the queue are coalesced into this run.
+	if interval := suite.spec.interval; interval > 0 {
+		if missed := int(time.Since(job.next) / interval); missed > 0 {
+			metrics.ScrapeSkippedCounter.WithLabelValues(target.Component, target.Kind).Add(float64(missed))
+		}
+	}
+	next := suite.runOnce(job.next, job.release)
+
+	s.mu.Lock()
+	if !job.removed && suite.ctx.Err() == nil {
+		job.next = next
+		heap.Push(&s.queue, job)
+	}
+	s.mu.Unlock()
+	s.notify()
+}
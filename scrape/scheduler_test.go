@@ -0,0 +1,112 @@
+package scrape
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		w.Write([]byte(testGoroutineProfile))
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	st := newTestStorage(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newScheduler(1, newScrapeLimiter(0, 0))
+	s.start(ctx)
+	newSuite := func(kind string, interval time.Duration) *ScrapeSuite {
+		target := NewTarget("tidb", address, kind, "http", &config.PprofProfilingConfig{})
+		spec := scrapeSpec{interval: interval, timeout: time.Second}
+		return newScrapeSuite(ctx, target.ProfileTarget, newScraper(target, http.DefaultClient), st, spec)
+	}
+
+	fast := newSuite("goroutine", 20*time.Millisecond)
+	slow := newSuite("heap", time.Hour)
+	s.add(fast, time.Now())
+	s.add(slow, time.Now())
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests["/debug/pprof/goroutine"] >= 3 && requests["/debug/pprof/heap"] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, HealthUp, fast.GetStatus().Health)
+	require.Equal(t, 0, s.queueDepth())
+
+	// the removed suite is not scheduled anymore.
+	s.remove(fast)
+	fast.stop()
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	count := requests["/debug/pprof/goroutine"]
+	mu.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	require.Equal(t, count, requests["/debug/pprof/goroutine"])
+	require.Equal(t, 1, requests["/debug/pprof/heap"])
+	mu.Unlock()
+
+	cancel()
+	s.wait()
+}
+
+func TestSchedulerLimiter(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/debug/pprof/profile" {
+			<-unblock
+		}
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newScheduler(2, newScrapeLimiter(0, 1))
+	s.start(ctx)
+	newSuite := func(component, kind string) *ScrapeSuite {
+		target := NewTarget(component, address, kind, "http", &config.PprofProfilingConfig{})
+		spec := scrapeSpec{interval: time.Hour, timeout: 5 * time.Second}
+		return newScrapeSuite(ctx, target.ProfileTarget, newScraper(target, http.DefaultClient), nil, spec)
+	}
+
+	// the second heavy scrape on the same host waits for the limiter without taking the idle worker.
+	now := time.Now()
+	s.add(newSuite("tidb", "profile"), now.Add(-2*time.Millisecond))
+	s.add(newSuite("tikv", "profile"), now.Add(-time.Millisecond))
+	s.add(newSuite("tidb", "goroutine"), now)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests["/debug/pprof/profile"] == 1 && requests["/debug/pprof/goroutine"] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, s.queueDepth())
+
+	close(unblock)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests["/debug/pprof/profile"] == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	s.wait()
+}
@@ -1,113 +1,240 @@
 package scrape
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"context"
 	"fmt"
+	"hash/fnv"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/discovery"
 	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/crazycs520/continuous-profile/metrics"
 	"github.com/crazycs520/continuous-profile/store"
 	"github.com/crazycs520/continuous-profile/util"
+	"github.com/google/pprof/profile"
 	"github.com/pingcap/log"
 	"github.com/pkg/errors"
+	commonconfig "github.com/prometheus/common/config"
 	"go.uber.org/zap"
 	"golang.org/x/net/context/ctxhttp"
 )
 
+// ErrProfileTooLarge is returned when the scraped profile exceeds the max profile size.
+var ErrProfileTooLarge = errors.New("profile size exceeds the limit")
+
+// ErrEmptyProfile is returned when the target responds an empty body, which is never a valid pprof profile.
+var ErrEmptyProfile = errors.New("empty profile")
+
+// ScrapeSuite scrapes a target periodically, it's run by the scheduler and only one run of a suite
+// is in progress at a time.
 type ScrapeSuite struct {
-	scraper        Scraper
-	lastScrape     time.Time
+	target meta.ProfileTarget
+	source ProfileSource
+	spec   scrapeSpec
+	store  *store.ProfileStorage
+	// builds is the build info fetched from the status endpoints, the build info of the component is used if nil.
+	builds *buildFetcher
+	ctx    context.Context
+	cancel func()
+
+	backoff        *backoff
+	buf            *bytes.Buffer
 	lastScrapeSize int
-	store          *store.ProfileStorage
-	ctx            context.Context
-	cancel         func()
+	// infoSynced is true after the target labels and build info are written into the store.
+	infoSynced bool
+	// instanceBound is true after the target is bound to its instance identity in the store.
+	instanceBound bool
+
+	mu     sync.Mutex
+	status ScrapeStatus
 }
 
-func newScrapeSuite(ctx context.Context, sc Scraper, store *store.ProfileStorage) *ScrapeSuite {
+func newScrapeSuite(ctx context.Context, target meta.ProfileTarget, source ProfileSource, store *store.ProfileStorage,
+	spec scrapeSpec) *ScrapeSuite {
 	sl := &ScrapeSuite{
-		scraper: sc,
+		target:  target,
+		source:  source,
+		spec:    spec,
 		store:   store,
+		backoff: newBackoff(spec.interval),
+		buf:     bytes.NewBuffer(make([]byte, 0, 1024)),
 	}
+	sl.status.Labels = spec.component.Labels
 	sl.ctx, sl.cancel = context.WithCancel(ctx)
 	return sl
 }
 
-func (sl *ScrapeSuite) run(interval, timeout time.Duration) {
-	target := sl.scraper.target
-	log.Info("scraper start to run",
-		zap.String("component", target.Component),
-		zap.String("address", target.Address),
-		zap.String("kind", target.Kind))
-	nextStart := time.Now().UnixNano() % int64(interval)
-	select {
-	case <-time.After(time.Duration(nextStart)):
-		// Continue after a scraping offset.
-	case <-sl.ctx.Done():
-		return
+// runOnce scrapes the target, or probes it if the circuit is open. It returns the time of the next run.
+// scheduled is the time the run is scheduled at, release is called to release the limiter once the profile
+// is collected.
+func (sl *ScrapeSuite) runOnce(scheduled time.Time, release func()) time.Time {
+	target := sl.target
+	start := time.Now()
+	var delay time.Duration
+	if sl.backoff.open {
+		err := probe(sl.ctx, target.Address, sl.spec.timeout)
+		release()
+		delay = sl.backoff.onProbe(err)
+		if err != nil {
+			log.Warn("probe target failed",
+				zap.String("component", target.Component),
+				zap.String("address", target.Address),
+				zap.String("kind", target.Kind),
+				zap.Duration("next-probe-delay", delay),
+				zap.Error(err))
+		} else {
+			log.Info("probe target succeeded, close the circuit",
+				zap.String("component", target.Component),
+				zap.String("address", target.Address),
+				zap.String("kind", target.Kind))
+		}
+	} else {
+		if sl.lastScrapeSize > 0 && sl.buf.Cap() > 2*sl.lastScrapeSize {
+			// shrink the buffer size.
+			sl.buf = bytes.NewBuffer(make([]byte, 0, sl.lastScrapeSize))
+		}
+		sl.buf.Reset()
+
+		var scrapeErr error
+		start, scrapeErr = sl.scrapeAndStore(sl.buf, sl.spec.timeout, scheduled, release)
+		if scrapeErr == nil {
+			sl.lastScrapeSize = sl.buf.Len()
+		}
+		delay = sl.backoff.onScrape(scrapeErr)
+		if scrapeErr == nil && sl.spec.aligned {
+			// start the next scrape at the next boundary, even if the scrape waited for the limiter.
+			delay = alignTime(start, sl.spec.interval).Add(sl.spec.interval).Sub(start)
+		}
 	}
 
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	next := start.Add(delay)
+	sl.updateBackoffStatus(sl.backoff, next, delay)
+	return next
+}
 
-	buf := bytes.NewBuffer(make([]byte, 0, 1024))
-	sl.lastScrapeSize = 0
+// firstRunTime returns the time of the first run, the suites are spread over the interval by the hash of
+// the target unless in aligned mode, so the suites started by the same reload don't run at the same time.
+func (sl *ScrapeSuite) firstRunTime() time.Time {
+	now := time.Now()
+	if sl.spec.interval <= 0 {
+		return now
+	}
+	if sl.spec.aligned {
+		return alignTime(now, sl.spec.interval).Add(sl.spec.interval)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(sl.target.Kind + "/" + sl.target.Component + "/" + sl.target.Address))
+	return now.Add(time.Duration(h.Sum64() % uint64(sl.spec.interval)))
+}
 
-	for {
-		start := time.Now()
-		if sl.lastScrapeSize > 0 && buf.Cap() > 2*sl.lastScrapeSize {
-			// shrink the buffer size.
-			buf = bytes.NewBuffer(make([]byte, 0, sl.lastScrapeSize))
-		}
+// alignTime returns the latest interval boundary which is not after t, the boundaries are counted from
+// the unix epoch, so they are the same on all the targets.
+func alignTime(t time.Time, interval time.Duration) time.Time {
+	if interval <= 0 {
+		return t
+	}
+	ns := t.UnixNano()
+	return time.Unix(0, ns-ns%int64(interval))
+}
 
-		buf.Reset()
+// scrapeAndStore scrapes the profile into buf and stores it, it returns the start time of the scrape and
+// the error of the scrape. The error of saving the profile is recorded in the status, but not returned
+// since it's not caused by the target. release is called once the profile is collected.
+func (sl *ScrapeSuite) scrapeAndStore(buf *bytes.Buffer, timeout time.Duration, scheduled time.Time, release func()) (time.Time, error) {
+	target := sl.target
+	start := time.Now()
+	scrapeCtx, cancel := context.WithTimeout(sl.ctx, timeout)
+	scrapeErr := sl.source.Collect(scrapeCtx, buf)
+	cancel()
+	release()
+	metrics.ScrapeCounter.WithLabelValues(target.Component, target.Kind).Inc()
+	duration := time.Since(start)
+	metrics.ScrapeDuration.WithLabelValues(target.Component, target.Kind).Observe(duration.Seconds())
 
-		scrapeCtx, cancel := context.WithTimeout(sl.ctx, timeout)
-		scrapeErr := sl.scraper.scrape(scrapeCtx, buf)
-		cancel()
+	if scrapeErr == nil {
+		metrics.ScrapeBytesCounter.WithLabelValues(target.Component, target.Kind).Add(float64(buf.Len()))
+	}
+	var format string
+	var p *profile.Profile
+	if scrapeErr == nil && buf.Len() > 0 {
+		format, p, scrapeErr = detectFormat(buf.Bytes(), &sl.spec.pprofConfig)
+		if scrapeErr != nil {
+			scrapeErr = errors.Wrap(scrapeErr, "invalid profile")
+		}
+	}
 
-		if scrapeErr == nil {
-			if buf.Len() > 0 {
-				sl.lastScrapeSize = buf.Len()
-				ts := util.GetTimeStamp(start)
-				err := sl.store.AddProfile(meta.ProfileTarget{
-					Kind:      sl.scraper.target.Kind,
-					Component: sl.scraper.target.Component,
-					Address:   sl.scraper.target.Address,
-				}, ts, buf.Bytes())
-
-				if err == nil {
-					sl.lastScrape = start
-				} else {
-					log.Error("save scrape data failed",
-						zap.String("component", target.Component),
-						zap.String("address", target.Address),
-						zap.String("kind", target.Kind),
-						zap.Int64("ts", ts),
-						zap.Error(err))
-				}
+	var saveErr error
+	if scrapeErr == nil {
+		if buf.Len() > 0 && !sl.skipProfile(p) {
+			ts := util.GetTimeStamp(start)
+			err := sl.bindInstance()
+			if err == nil {
+				err = sl.store.AddProfile(target, ts, buf.Bytes(), sl.profileAttr(format, scheduled))
 			}
-		} else {
-			log.Error("scrape failed",
-				zap.String("component", target.Component),
-				zap.String("address", target.Address),
-				zap.String("kind", target.Kind),
-				zap.Error(scrapeErr))
-		}
 
-		select {
-		case <-sl.ctx.Done():
-			return
-		case <-ticker.C:
+			if errors.Is(err, store.ErrProfileExists) {
+				// the timestamp is taken by a manual capture of the target, skip this scrape instead of
+				// reporting the target as unhealthy.
+				log.Info("skip the scrape since the profile at the same timestamp exists",
+					zap.String("component", target.Component),
+					zap.String("address", target.Address),
+					zap.String("kind", target.Kind),
+					zap.Int64("ts", ts))
+			} else if err != nil {
+				log.Error("save scrape data failed",
+					zap.String("component", target.Component),
+					zap.String("address", target.Address),
+					zap.String("kind", target.Kind),
+					zap.Int64("ts", ts),
+					zap.Error(err))
+				saveErr = errors.Wrap(err, "save scrape data failed")
+			} else if !sl.infoSynced {
+				// the target table is created by the first profile, write the labels and build info now
+				// instead of waiting for the target meta loop.
+				_, err = sl.syncTargetInfo(ts)
+				sl.infoSynced = err == nil
+			}
 		}
+	} else {
+		log.Error("scrape failed",
+			zap.String("component", target.Component),
+			zap.String("address", target.Address),
+			zap.String("kind", target.Kind),
+			zap.Error(scrapeErr))
 	}
+	statusErr := scrapeErr
+	if statusErr == nil {
+		statusErr = saveErr
+	}
+	if statusErr != nil {
+		metrics.ScrapeErrorCounter.WithLabelValues(target.Component, target.Kind).Inc()
+	}
+	sl.updateStatus(start, duration, buf.Len(), statusErr)
+	return start, scrapeErr
+}
+
+// profileAttr returns the attributes of the profile. The round is decided by the scheduled time instead of the
+// start time, so the profiles of the same round are tagged the same even if some of them waited for the limiter.
+func (sl *ScrapeSuite) profileAttr(format string, scheduled time.Time) meta.ProfileAttr {
+	attr := meta.ProfileAttr{Format: format}
+	if sl.spec.aligned {
+		attr.Round = alignTime(scheduled, sl.spec.interval).Unix()
+	}
+	return attr
+}
+
+// skipProfile returns true if the profile is empty and the profile kind is configured to skip empty profile.
+func (sl *ScrapeSuite) skipProfile(p *profile.Profile) bool {
+	return sl.spec.pprofConfig.SkipEmpty && p != nil && len(p.Sample) == 0
 }
 
 // Stop the scraping. May still write data and stale markers after it has
@@ -117,28 +244,76 @@ func (sl *ScrapeSuite) stop() {
 }
 
 func (sl *ScrapeSuite) LastScrapeSize() int {
-	return sl.lastScrapeSize
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.status.LastSize
+}
+
+// bindInstance binds the target to its instance identity before storing the first profile, so the history of
+// the instance is continuous if its address changed.
+func (sl *ScrapeSuite) bindInstance() error {
+	if sl.instanceBound {
+		return nil
+	}
+	err := sl.store.BindProfileTargetInstance(sl.target, sl.spec.component.Instance)
+	sl.instanceBound = err == nil
+	return err
+}
+
+// syncTargetInfo writes the last scrape ts, the labels and the build info of the target into the store.
+func (sl *ScrapeSuite) syncTargetInfo(ts int64) (bool, error) {
+	// the labels is not nil to clear the labels which are removed.
+	labels := sl.spec.component.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	updated, err := sl.store.UpdateProfileTargetInfo(sl.target, ts, labels)
+	if err != nil {
+		return updated, err
+	}
+	buildUpdated, err := sl.store.UpdateProfileTargetBuild(sl.target, sl.builds.get(sl.spec.component), ts)
+	return updated || buildUpdated, err
+}
+
+func componentBuild(comp discovery.Component) meta.BuildInfo {
+	return meta.BuildInfo{
+		Version:   comp.Version,
+		GitHash:   comp.GitHash,
+		StartTime: comp.StartTimestamp,
+	}
 }
 
+// GetInterval returns the scrape interval of the suite.
+func (sl *ScrapeSuite) GetInterval() time.Duration {
+	return sl.spec.interval
+}
+
+// Scraper is the ProfileSource which pulls the profile from the pprof HTTP API of the target.
 type Scraper struct {
 	target *Target
 	client *http.Client
 	req    *http.Request
 }
 
-func newScraper(target *Target, client *http.Client) Scraper {
-	return Scraper{
+func newScraper(target *Target, client *http.Client) *Scraper {
+	return &Scraper{
 		target: target,
 		client: client,
 	}
 }
 
-func (s *Scraper) scrape(ctx context.Context, w io.Writer) error {
+func newHTTPSource(target meta.ProfileTarget, pprofConfig *config.PprofProfilingConfig) (ProfileSource, error) {
 	cfg := config.GetGlobalConfig()
-	if !cfg.ContinueProfiling.Enable {
-		return nil
+	client, err := commonconfig.NewClientFromConfig(cfg.Security.GetHTTPClientConfig(), target.Component)
+	if err != nil {
+		return nil, err
 	}
+	scrapeTarget := NewTarget(target.Component, target.Address, target.Kind, cfg.GetHTTPScheme(), pprofConfig)
+	return newScraper(scrapeTarget, client), nil
+}
 
+// Collect implements the ProfileSource interface.
+func (s *Scraper) Collect(ctx context.Context, w io.Writer) error {
 	if s.req == nil {
 		req, err := http.NewRequest("GET", s.target.GetURLString(), nil)
 		if err != nil {
@@ -163,32 +338,46 @@ func (s *Scraper) scrape(ctx context.Context, w io.Writer) error {
 		return fmt.Errorf("server returned HTTP status %s", resp.Status)
 	}
 
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return errors.Wrap(err, "failed to read body")
+	body := bufio.NewReader(resp.Body)
+	if _, err := body.Peek(1); err == io.EOF {
+		return errors.Wrap(ErrEmptyProfile, "invalid profile")
 	}
-
-	data := s.tryUnzip(b)
-	_, err = w.Write(data)
-	return err
+	return copyProfile(w, body, s.target.maxSize)
 }
 
-func (s *Scraper) tryUnzip(data []byte) []byte {
-	gz, err := gzip.NewReader(bytes.NewBuffer(data))
-	if err != nil {
-		return data
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// copyProfile streams the profile from r into w, the gzip compressed profile is decompressed on the fly.
+// The copy is aborted if the decompressed profile is larger than maxSize, 0 means no limit.
+func copyProfile(w io.Writer, r io.Reader, maxSize int) error {
+	br := bufio.NewReader(r)
+	src := io.Reader(br)
+	if header, err := br.Peek(len(gzipMagic)); err == nil && bytes.Equal(header, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return errors.Wrap(err, "failed to decompress body")
+		}
+		defer gz.Close()
+		src = gz
+	}
+	if maxSize > 0 {
+		src = io.LimitReader(src, int64(maxSize)+1)
 	}
-	v, err := ioutil.ReadAll(gz)
+	n, err := io.Copy(w, src)
 	if err != nil {
-		return data
+		return errors.Wrap(err, "failed to read body")
+	}
+	if maxSize > 0 && n > int64(maxSize) {
+		return errors.Wrapf(ErrProfileTooLarge, "max size %v", maxSize)
 	}
-	return v
+	return nil
 }
 
 // Target refers to a singular HTTP or HTTPS endpoint.
 type Target struct {
 	meta.ProfileTarget
-	header map[string]string
+	header  map[string]string
+	maxSize int
 	*url.URL
 }
 
@@ -209,10 +398,11 @@ func NewTarget(component, address, kind, schema string, cfg *config.PprofProfili
 	}
 
 	t.header = cfg.Header
+	t.maxSize = cfg.MaxSize
 	t.URL = &url.URL{
 		Scheme:   schema,
 		Host:     t.Address,
-		Path:     cfg.Path,
+		Path:     cfg.GetPath(kind),
 		RawQuery: vs.Encode(),
 	}
 	return t
@@ -0,0 +1,243 @@
+package scrape
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/discovery"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/crazycs520/continuous-profile/metrics"
+	"github.com/crazycs520/continuous-profile/util"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyProfile(t *testing.T) {
+	data := strings.Repeat("goroutine 1 [running]:\n", 100)
+	compressed := bytes.NewBuffer(nil)
+	gz := gzip.NewWriter(compressed)
+	_, err := gz.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	for _, src := range [][]byte{[]byte(data), compressed.Bytes()} {
+		buf := bytes.NewBuffer(nil)
+		require.NoError(t, copyProfile(buf, bytes.NewReader(src), 0))
+		require.Equal(t, data, buf.String())
+
+		buf.Reset()
+		require.NoError(t, copyProfile(buf, bytes.NewReader(src), len(data)))
+		require.Equal(t, data, buf.String())
+
+		buf.Reset()
+		err = copyProfile(buf, bytes.NewReader(src), len(data)-1)
+		require.True(t, errors.Is(err, ErrProfileTooLarge))
+		require.LessOrEqual(t, buf.Len(), len(data))
+	}
+}
+
+func TestAlignTime(t *testing.T) {
+	interval := time.Minute
+	ts := time.Date(2021, 10, 14, 10, 0, 5, 0, time.Local)
+	aligned := alignTime(ts, interval)
+	require.Equal(t, int64(0), aligned.Unix()%60)
+	require.Equal(t, 5*time.Second, ts.Sub(aligned))
+	require.Equal(t, aligned, alignTime(aligned, interval))
+	require.Equal(t, aligned, alignTime(aligned.Add(interval-time.Nanosecond), interval))
+
+	suite := &ScrapeSuite{spec: scrapeSpec{interval: interval, aligned: true}}
+	require.Equal(t, aligned.Unix(), suite.profileAttr(meta.ProfileFormatProtobuf, ts).Round)
+	// the round is decided by the scheduled time, the wait of the limiter doesn't move it to the next round.
+	require.Equal(t, aligned.Unix(), suite.profileAttr(meta.ProfileFormatProtobuf, aligned).Round)
+	next := suite.firstRunTime()
+	require.Equal(t, int64(0), next.UnixNano()%int64(interval))
+	require.True(t, next.After(time.Now()))
+	suite.spec.aligned = false
+	require.Equal(t, int64(0), suite.profileAttr(meta.ProfileFormatProtobuf, ts).Round)
+}
+
+func TestFirstRunTime(t *testing.T) {
+	interval := time.Minute
+	offsets := make(map[time.Duration]struct{})
+	for i := 0; i < 10; i++ {
+		target := meta.ProfileTarget{Kind: "profile", Component: "tikv", Address: fmt.Sprintf("10.0.1.%d:20180", i)}
+		suite := &ScrapeSuite{target: target, spec: scrapeSpec{interval: interval}}
+		start := time.Now()
+		offset := suite.firstRunTime().Sub(start)
+		require.True(t, offset >= 0 && offset < interval+time.Second, offset)
+		// the offset is decided by the target, it doesn't depend on the start time.
+		time.Sleep(time.Millisecond)
+		start = time.Now()
+		require.InDelta(t, float64(offset), float64(suite.firstRunTime().Sub(start)), float64(time.Millisecond))
+		offsets[offset.Truncate(time.Second)] = struct{}{}
+	}
+	// the suites started at the same time are spread over the interval.
+	require.Greater(t, len(offsets), 5)
+}
+
+func TestAlignedHeavyConflictHosts(t *testing.T) {
+	aligned := &scrapeSpec{interval: time.Minute, aligned: true}
+	specs := map[meta.ProfileTarget]*scrapeSpec{
+		{Kind: "profile", Component: "tidb", Address: "10.0.1.1:10080"}:   aligned,
+		{Kind: "profile", Component: "tikv", Address: "10.0.1.1:20180"}:   aligned,
+		{Kind: "goroutine", Component: "tikv", Address: "10.0.1.2:20180"}: aligned,
+		{Kind: "goroutine", Component: "tidb", Address: "10.0.1.2:10080"}: aligned,
+		{Kind: "profile", Component: "tidb", Address: "10.0.1.3:10080"}:   aligned,
+		{Kind: "profile", Component: "pd", Address: "10.0.1.3:2379"}:      {interval: time.Minute},
+	}
+	require.Equal(t, []string{"10.0.1.1"}, getAlignedHeavyConflictHosts(specs, 1))
+	require.Len(t, getAlignedHeavyConflictHosts(specs, 2), 0)
+	require.Len(t, getAlignedHeavyConflictHosts(specs, 0), 0)
+}
+
+func TestBuildComponentMap(t *testing.T) {
+	config.StoreGlobalConfig(config.NewConfig())
+	m := NewManager(nil, nil)
+	m.staticJobs = buildStaticJobs([]*config.ScrapeConfig{{
+		ComponentName:  discovery.ComponentTiKV,
+		ScrapeInterval: 30 * time.Second,
+		Targets:        []string{"10.0.1.1:20180"},
+		Labels:         map[string]string{"team": "storage", "zone": "z2"},
+	}})
+	// the port of the discovered TiKV is different from the status port in the scrape_configs.
+	discovered := discovery.Component{
+		Name:       discovery.ComponentTiKV,
+		IP:         "10.0.1.1",
+		Port:       20160,
+		StatusPort: 20180,
+		Labels:     map[string]string{"zone": "z1", "host": "h1"},
+		Version:    "v5.3.0",
+		Instance:   "store-1",
+	}
+	compMap := m.buildComponentMap([]discovery.Component{discovered})
+	require.Len(t, compMap, 1)
+	comp := compMap[discovered.Key()]
+	// the labels of the static job override the discovered labels.
+	require.Equal(t, map[string]string{"team": "storage", "zone": "z2", "host": "h1"}, comp.Labels)
+	// the build info and the identity of the discovered component are kept.
+	require.Equal(t, meta.BuildInfo{Version: "v5.3.0"}, componentBuild(comp))
+	require.Equal(t, "store-1", comp.Instance)
+	require.Equal(t, uint(20160), comp.Port)
+
+	// each target has exactly one spec, which uses the merged labels and the interval of the static job.
+	m.lastComponents = compMap
+	for i := 0; i < 10; i++ {
+		specs := m.buildScrapeSpecs(config.GetGlobalConfig().ContinueProfiling)
+		require.Len(t, specs, len(config.GetGlobalConfig().ContinueProfiling.GetPprofConfig(discovery.ComponentTiKV)))
+		for target, spec := range specs {
+			require.Equal(t, "10.0.1.1:20180", target.Address)
+			require.Equal(t, comp, spec.component)
+			require.Equal(t, 30*time.Second, spec.interval)
+		}
+	}
+
+	compMap = m.buildComponentMap(nil)
+	require.Equal(t, map[string]string{"team": "storage", "zone": "z2"}, compMap[discovered.Key()].Labels)
+}
+
+func TestScrapeStatus(t *testing.T) {
+	var failed int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		if atomic.LoadInt32(&failed) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(testGoroutineProfile))
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	m, _, _ := newTestManager(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	target := NewTarget(discovery.ComponentTiDB, address, "goroutine", "http", &config.PprofProfilingConfig{})
+	suite := newScrapeSuite(ctx, target.ProfileTarget, newScraper(target, http.DefaultClient), m.store, scrapeSpec{interval: time.Minute, timeout: time.Second})
+	m.addScrapeSuite(target.ProfileTarget, suite)
+	scrapeCounter := metrics.ScrapeCounter.WithLabelValues(target.Component, target.Kind)
+	errCounter := metrics.ScrapeErrorCounter.WithLabelValues(target.Component, target.Kind)
+	bytesCounter := metrics.ScrapeBytesCounter.WithLabelValues(target.Component, target.Kind)
+	scrapes, errs, scrapedBytes := testutil.ToFloat64(scrapeCounter), testutil.ToFloat64(errCounter), testutil.ToFloat64(bytesCounter)
+	status := m.GetScrapeStatuses()[0]
+	require.Equal(t, target.ProfileTarget, status.Target)
+	require.Equal(t, HealthUnknown, status.Health)
+
+	// success
+	suite.runOnce(time.Now(), func() {})
+	status = m.GetScrapeStatuses()[0]
+	require.Equal(t, HealthUp, status.Health)
+	require.False(t, status.LastSuccess.IsZero())
+	require.Equal(t, status.LastScrape, status.LastSuccess)
+	require.Empty(t, status.LastError)
+	require.Equal(t, 0, status.ConsecutiveFailures)
+	require.GreaterOrEqual(t, status.LastDurationMs, int64(20))
+	require.Equal(t, len(testGoroutineProfile), status.LastSize)
+	require.Equal(t, scrapes+1, testutil.ToFloat64(scrapeCounter))
+	require.Equal(t, errs, testutil.ToFloat64(errCounter))
+	require.Equal(t, scrapedBytes+float64(len(testGoroutineProfile)), testutil.ToFloat64(bytesCounter))
+	lastSuccess := status.LastSuccess
+
+	// failure, the last success and the last size are kept.
+	atomic.StoreInt32(&failed, 1)
+	for i := 1; i <= 2; i++ {
+		time.Sleep(time.Millisecond)
+		suite.runOnce(time.Now(), func() {})
+		status = m.GetScrapeStatuses()[0]
+		require.Equal(t, HealthDown, status.Health)
+		require.Equal(t, lastSuccess, status.LastSuccess)
+		require.True(t, status.LastScrape.After(lastSuccess))
+		require.Contains(t, status.LastError, "500")
+		require.Equal(t, i, status.ConsecutiveFailures)
+		require.GreaterOrEqual(t, status.LastDurationMs, int64(20))
+		require.Equal(t, len(testGoroutineProfile), status.LastSize)
+		require.Equal(t, scrapes+1+float64(i), testutil.ToFloat64(scrapeCounter))
+		require.Equal(t, errs+float64(i), testutil.ToFloat64(errCounter))
+	}
+
+	// success again
+	atomic.StoreInt32(&failed, 0)
+	time.Sleep(time.Millisecond)
+	suite.runOnce(time.Now(), func() {})
+	status = m.GetScrapeStatuses()[0]
+	require.Equal(t, HealthUp, status.Health)
+	require.True(t, status.LastSuccess.After(lastSuccess))
+	require.Empty(t, status.LastError)
+	require.Equal(t, 0, status.ConsecutiveFailures)
+}
+
+func TestScrapeEmptyBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	m, st, _ := newTestManager(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	target := NewTarget(discovery.ComponentTiDB, address, "goroutine", "http", &config.PprofProfilingConfig{})
+	suite := newScrapeSuite(ctx, target.ProfileTarget, newScraper(target, http.DefaultClient), m.store, scrapeSpec{interval: time.Minute, timeout: time.Second})
+	errCounter := metrics.ScrapeErrorCounter.WithLabelValues(target.Component, target.Kind)
+	errCount := testutil.ToFloat64(errCounter)
+
+	// the empty body of HTTP 200 is an invalid profile.
+	suite.runOnce(time.Now(), func() {})
+	status := suite.GetStatus()
+	require.Equal(t, HealthDown, status.Health)
+	require.Contains(t, status.LastError, "invalid profile")
+	require.Equal(t, 1, status.ConsecutiveFailures)
+	require.Equal(t, errCount+1, testutil.ToFloat64(errCounter))
+	lists, err := st.QueryProfileList(&meta.BasicQueryParam{Begin: 0, End: util.GetTimeStamp(time.Now()) + 1, Targets: []meta.ProfileTarget{target.ProfileTarget}})
+	require.NoError(t, err)
+	for _, list := range lists {
+		require.Empty(t, list.TsList)
+	}
+}
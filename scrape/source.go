@@ -0,0 +1,74 @@
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/meta"
+)
+
+const (
+	// SourceHTTP pulls the profile from the pprof HTTP API of the target, it's the default source.
+	SourceHTTP = "http"
+	// SourceExec runs a local command to collect the profile, such as `perf record`.
+	SourceExec = "exec"
+	// SourceFile takes the profile files dropped into a directory.
+	SourceFile = "file"
+)
+
+// ProfileSource collects the profile of a target, the Manager schedules it periodically.
+type ProfileSource interface {
+	// Collect writes a profile into w, writing nothing means there is no profile this time.
+	// The profile larger than the max_size of the profile kind should be aborted with ErrProfileTooLarge.
+	Collect(ctx context.Context, w io.Writer) error
+}
+
+// SourceFactory creates the ProfileSource of the target with the pprof config of the profile kind.
+type SourceFactory func(target meta.ProfileTarget, pc *config.PprofProfilingConfig) (ProfileSource, error)
+
+var (
+	sourceMu        sync.RWMutex
+	sourceFactories = map[string]SourceFactory{
+		SourceHTTP: newHTTPSource,
+		SourceExec: newExecSource,
+		SourceFile: newFileSource,
+	}
+)
+
+// RegisterSource registers a ProfileSource factory, the profile kind whose `source` is name uses it.
+// It's used to plug in other collectors, and should be called before the Manager starts.
+func RegisterSource(name string, factory SourceFactory) {
+	sourceMu.Lock()
+	defer sourceMu.Unlock()
+	sourceFactories[name] = factory
+}
+
+// GetSourceNames returns the names of all the registered sources.
+func GetSourceNames() []string {
+	sourceMu.RLock()
+	defer sourceMu.RUnlock()
+	names := make([]string, 0, len(sourceFactories))
+	for name := range sourceFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newProfileSource(target meta.ProfileTarget, pc *config.PprofProfilingConfig) (ProfileSource, error) {
+	name := pc.Source
+	if name == "" {
+		name = SourceHTTP
+	}
+	sourceMu.RLock()
+	factory, ok := sourceFactories[name]
+	sourceMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown profile source `%v`", name)
+	}
+	return factory(target, pc)
+}
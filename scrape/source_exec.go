@@ -0,0 +1,138 @@
+package scrape
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/pkg/errors"
+)
+
+const (
+	placeholderOutput  = "{output}"
+	placeholderSeconds = "{seconds}"
+	placeholderAddress = "{address}"
+	placeholderHost    = "{host}"
+
+	// maxExecStderrSize is the max size of the stderr which is kept in the error message.
+	maxExecStderrSize = 1024
+)
+
+// execSource runs a local command to collect the profile. The profile is read from the {output} file
+// if the command has the {output} placeholder, otherwise from the stdout.
+type execSource struct {
+	target  meta.ProfileTarget
+	command []string
+	seconds int
+	maxSize int
+}
+
+func newExecSource(target meta.ProfileTarget, pc *config.PprofProfilingConfig) (ProfileSource, error) {
+	if len(pc.Command) == 0 {
+		return nil, errors.New("the command of exec source should not be empty")
+	}
+	return &execSource{
+		target:  target,
+		command: pc.Command,
+		seconds: pc.Seconds,
+		maxSize: pc.MaxSize,
+	}, nil
+}
+
+// Collect implements the ProfileSource interface.
+func (s *execSource) Collect(ctx context.Context, w io.Writer) error {
+	output := ""
+	for _, arg := range s.command {
+		if strings.Contains(arg, placeholderOutput) {
+			dir, err := ioutil.TempDir("", "conprof-exec-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+			output = filepath.Join(dir, "profile")
+			break
+		}
+	}
+	replacer := strings.NewReplacer(
+		placeholderOutput, output,
+		placeholderSeconds, strconv.Itoa(s.seconds),
+		placeholderAddress, s.target.Address,
+		placeholderHost, getHost(s.target.Address),
+	)
+	args := make([]string, 0, len(s.command))
+	for _, arg := range s.command {
+		args = append(args, replacer.Replace(arg))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	stderr := &limitedBuffer{limit: maxExecStderrSize}
+	cmd.Stderr = stderr
+	if output != "" {
+		err := cmd.Run()
+		if err != nil {
+			return wrapExecError(err, stderr)
+		}
+		f, err := os.Open(output)
+		if err != nil {
+			return errors.Wrap(err, "open the output of command failed")
+		}
+		defer f.Close()
+		return copyProfile(w, f, s.maxSize)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	copyErr := copyProfile(w, stdout, s.maxSize)
+	if copyErr != nil {
+		// kill the command since its output is dropped.
+		cancel()
+	}
+	err = cmd.Wait()
+	if copyErr != nil {
+		return copyErr
+	}
+	if err != nil {
+		return wrapExecError(err, stderr)
+	}
+	return nil
+}
+
+func wrapExecError(err error, stderr *limitedBuffer) error {
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return errors.Wrapf(err, "run command failed, stderr: %v", msg)
+	}
+	return errors.Wrap(err, "run command failed")
+}
+
+// limitedBuffer keeps the first limit bytes written into it.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remain := b.limit - b.Len(); remain > 0 {
+		if len(p) > remain {
+			b.Buffer.Write(p[:remain])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,92 @@
+package scrape
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/pingcap/log"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// fileSource takes the profile files dropped into a directory, each collect takes the oldest file and
+// removes it. The file whose name starts with "." is ignored, the writer should write the profile into
+// a hidden file and then rename it to avoid the partial profile being taken.
+type fileSource struct {
+	target  meta.ProfileTarget
+	dir     string
+	pattern string
+	maxSize int
+}
+
+func newFileSource(target meta.ProfileTarget, pc *config.PprofProfilingConfig) (ProfileSource, error) {
+	if pc.Dir == "" {
+		return nil, errors.New("the dir of file source should not be empty")
+	}
+	pattern := pc.Pattern
+	if pattern == "" {
+		pattern = "*"
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, errors.Wrapf(err, "invalid pattern `%v`", pattern)
+	}
+	return &fileSource{
+		target:  target,
+		dir:     pc.Dir,
+		pattern: pattern,
+		maxSize: pc.MaxSize,
+	}, nil
+}
+
+// Collect implements the ProfileSource interface.
+func (s *fileSource) Collect(ctx context.Context, w io.Writer) error {
+	name, err := s.oldestFile()
+	if err != nil || name == "" {
+		return err
+	}
+	path := filepath.Join(s.dir, name)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = copyProfile(w, f, s.maxSize)
+	f.Close()
+	// the file is removed even if it's too large, otherwise it will block the following files.
+	if rmErr := os.Remove(path); rmErr != nil {
+		log.Warn("remove the collected profile file failed",
+			zap.String("component", s.target.Component),
+			zap.String("kind", s.target.Kind),
+			zap.String("path", path),
+			zap.Error(rmErr))
+	}
+	return errors.Wrapf(err, "collect file %v failed", path)
+}
+
+func (s *fileSource) oldestFile() (string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return "", err
+	}
+	var oldest os.FileInfo
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		if matched, _ := filepath.Match(s.pattern, file.Name()); !matched {
+			continue
+		}
+		if oldest == nil || file.ModTime().Before(oldest.ModTime()) {
+			oldest = file
+		}
+	}
+	if oldest == nil {
+		return "", nil
+	}
+	return oldest.Name(), nil
+}
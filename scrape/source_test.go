@@ -0,0 +1,89 @@
+package scrape
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecSource(t *testing.T) {
+	target := meta.ProfileTarget{Kind: "perf", Component: "tikv", Address: "10.0.1.1:20180"}
+	collect := func(pc *config.PprofProfilingConfig) (string, error) {
+		pc.Source = SourceExec
+		source, err := newProfileSource(target, pc)
+		require.NoError(t, err)
+		buf := bytes.NewBuffer(nil)
+		err = source.Collect(context.Background(), buf)
+		return buf.String(), err
+	}
+
+	data, err := collect(&config.PprofProfilingConfig{
+		Command: []string{"sh", "-c", "echo {host} {address} {seconds}"},
+		Seconds: 10,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "10.0.1.1 10.0.1.1:20180 10\n", data)
+
+	// the profile is read from the output file, such as `perf record -o {output}`.
+	data, err = collect(&config.PprofProfilingConfig{
+		Command: []string{"sh", "-c", "echo hello > {output}"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", data)
+
+	_, err = collect(&config.PprofProfilingConfig{
+		Command: []string{"sh", "-c", "echo hello"},
+		MaxSize: 3,
+	})
+	require.True(t, errors.Is(err, ErrProfileTooLarge))
+
+	_, err = collect(&config.PprofProfilingConfig{
+		Command: []string{"sh", "-c", "echo permission denied >&2; exit 1"},
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "permission denied")
+
+	_, err = newProfileSource(target, &config.PprofProfilingConfig{Source: SourceExec})
+	require.Error(t, err)
+	_, err = newProfileSource(target, &config.PprofProfilingConfig{Source: "unknown"})
+	require.Error(t, err)
+}
+
+func TestFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conprof-file-source-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	target := meta.ProfileTarget{Kind: "profile", Component: "job", Address: "10.0.1.1:0"}
+	source, err := newProfileSource(target, &config.PprofProfilingConfig{Source: SourceFile, Dir: dir, Pattern: "*.pb"})
+	require.NoError(t, err)
+
+	writeFile := func(name, data string, modTime time.Time) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	now := time.Now()
+	writeFile("2.pb", "second", now)
+	writeFile("1.pb", "first", now.Add(-time.Minute))
+	writeFile(".3.pb", "writing", now.Add(-time.Hour))
+	writeFile("4.txt", "ignored", now.Add(-time.Hour))
+
+	for _, expected := range []string{"first", "second", ""} {
+		buf := bytes.NewBuffer(nil)
+		require.NoError(t, source.Collect(context.Background(), buf))
+		require.Equal(t, expected, buf.String())
+	}
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+}
@@ -0,0 +1,84 @@
+package scrape
+
+import (
+	"time"
+
+	"github.com/crazycs520/continuous-profile/meta"
+)
+
+const (
+	HealthUnknown = "unknown"
+	HealthUp      = "up"
+	HealthDown    = "down"
+)
+
+// ScrapeStatus is the health status of a scrape target.
+type ScrapeStatus struct {
+	Target meta.ProfileTarget `json:"target"`
+	Labels map[string]string  `json:"labels,omitempty"`
+	Health string             `json:"health"`
+	// LastScrape is the start time of the last scrape.
+	LastScrape  time.Time `json:"last_scrape"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error"`
+	// ConsecutiveFailures is the failure count since the last success.
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// LastDurationMs is the time of collecting the last profile from the target, the storage time is not included.
+	LastDurationMs int64 `json:"last_duration_ms"`
+	// LastSize is the size of the last scraped profile in bytes.
+	LastSize int `json:"last_size"`
+	// Circuit is open when the target refused the connection, only the probe is sent until the probe succeeds.
+	Circuit string `json:"circuit"`
+	// NextScrape is the time of the next scrape or probe.
+	NextScrape time.Time `json:"next_scrape"`
+	// BackoffMs is the delay of the next retry after the failures, 0 means not in backoff.
+	BackoffMs int64 `json:"backoff_ms"`
+}
+
+// updateStatus records the result of the scrape started at start, duration is the time of collecting the profile,
+// which doesn't include the validation and the storage.
+func (sl *ScrapeSuite) updateStatus(start time.Time, duration time.Duration, size int, err error) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.status.LastScrape = start
+	sl.status.LastDurationMs = duration.Milliseconds()
+	if err != nil {
+		sl.status.Health = HealthDown
+		sl.status.LastError = err.Error()
+		sl.status.ConsecutiveFailures++
+		return
+	}
+	sl.status.Health = HealthUp
+	sl.status.LastSuccess = start
+	sl.status.LastError = ""
+	sl.status.ConsecutiveFailures = 0
+	if size > 0 {
+		sl.status.LastSize = size
+	}
+}
+
+func (sl *ScrapeSuite) updateBackoffStatus(bo *backoff, next time.Time, delay time.Duration) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.status.Circuit = bo.circuit()
+	sl.status.NextScrape = next
+	sl.status.BackoffMs = 0
+	if bo.failures > 0 {
+		sl.status.BackoffMs = delay.Milliseconds()
+	}
+}
+
+// GetStatus returns a copy of the scrape status.
+func (sl *ScrapeSuite) GetStatus() ScrapeStatus {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	status := sl.status
+	status.Target = sl.target
+	if status.Health == "" {
+		status.Health = HealthUnknown
+	}
+	if status.Circuit == "" {
+		status.Circuit = CircuitClosed
+	}
+	return status
+}
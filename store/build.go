@@ -0,0 +1,136 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/genjidb/genji/document"
+	"github.com/genjidb/genji/types"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+const buildTableName = tableNamePrefix + "_targets_build"
+
+func (s *ProfileStorage) initBuildTable() error {
+	sql := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v (target_id INTEGER, version TEXT, git_hash TEXT, start_time INTEGER, begin_ts INTEGER, end_ts INTEGER)", buildTableName)
+	err := s.db.Exec(sql)
+	if err != nil {
+		return err
+	}
+	sql = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %v_target_id ON %v (target_id)", buildTableName, buildTableName)
+	return s.db.Exec(sql)
+}
+
+// UpdateProfileTargetBuild records the build info of the target at ts. A new time range is started if the
+// build info is changed, otherwise the current time range is extended to ts. It returns true if updated.
+func (s *ProfileStorage) UpdateProfileTargetBuild(pt meta.ProfileTarget, build meta.BuildInfo, ts int64) (bool, error) {
+	if s.isClose() {
+		return false, ErrStoreIsClosed
+	}
+	if build.IsEmpty() {
+		return false, nil
+	}
+	info := s.getTargetInfoFromCache(pt)
+	if info == nil {
+		return false, nil
+	}
+	s.Lock()
+	latest := info.Build
+	s.Unlock()
+
+	if latest != nil && latest.BuildInfo == build {
+		if ts <= latest.EndTs {
+			return false, nil
+		}
+		sql := fmt.Sprintf("UPDATE %v SET end_ts = ? WHERE target_id = ? AND begin_ts = ?", buildTableName)
+		err := s.db.Exec(sql, ts, info.ID, latest.BeginTs)
+		if err != nil {
+			return false, err
+		}
+		s.Lock()
+		info.Build = &meta.TargetBuild{BuildInfo: build, BeginTs: latest.BeginTs, EndTs: ts}
+		s.Unlock()
+		return true, nil
+	}
+	if latest != nil && ts <= latest.EndTs {
+		// the profile is older than the current build, such as the profile pushed with an old timestamp.
+		return false, nil
+	}
+
+	sql := fmt.Sprintf("INSERT INTO %v (target_id, version, git_hash, start_time, begin_ts, end_ts) VALUES (?, ?, ?, ?, ?, ?)", buildTableName)
+	err := s.db.Exec(sql, info.ID, build.Version, build.GitHash, build.StartTime, ts, ts)
+	if err != nil {
+		return false, err
+	}
+	s.Lock()
+	info.Build = &meta.TargetBuild{BuildInfo: build, BeginTs: ts, EndTs: ts}
+	s.Unlock()
+	log.Info("update profile target build",
+		zap.String("component", pt.Component),
+		zap.String("address", pt.Address),
+		zap.String("kind", pt.Kind),
+		zap.String("version", build.Version),
+		zap.String("git-hash", build.GitHash),
+		zap.Int64("start-time", build.StartTime))
+	return true, nil
+}
+
+// queryTargetBuilds returns the build info of the target which overlaps the time range [begin, end].
+func (s *ProfileStorage) queryTargetBuilds(info *meta.TargetInfo, begin, end int64) ([]meta.TargetBuild, error) {
+	query := fmt.Sprintf("SELECT version, git_hash, start_time, begin_ts, end_ts FROM %v WHERE target_id = ? AND end_ts >= ? AND begin_ts <= ? ORDER BY begin_ts", buildTableName)
+	return s.queryBuilds(query, info.ID, begin, end)
+}
+
+// loadLatestBuild returns the latest build info of the target, it's nil if the target has no build info.
+func (s *ProfileStorage) loadLatestBuild(id int64) (*meta.TargetBuild, error) {
+	query := fmt.Sprintf("SELECT version, git_hash, start_time, begin_ts, end_ts FROM %v WHERE target_id = ? ORDER BY begin_ts DESC LIMIT 1", buildTableName)
+	builds, err := s.queryBuilds(query, id)
+	if err != nil || len(builds) == 0 {
+		return nil, err
+	}
+	return &builds[0], nil
+}
+
+func (s *ProfileStorage) queryBuilds(query string, args ...interface{}) ([]meta.TargetBuild, error) {
+	res, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var builds []meta.TargetBuild
+	err = res.Iterate(func(d types.Document) error {
+		var b meta.TargetBuild
+		err := document.Scan(d, &b.Version, &b.GitHash, &b.StartTime, &b.BeginTs, &b.EndTs)
+		if err != nil {
+			return err
+		}
+		builds = append(builds, b)
+		return nil
+	})
+	return builds, err
+}
+
+func (s *ProfileStorage) deleteTargetBuilds(id int64) error {
+	sql := fmt.Sprintf("DELETE FROM %v WHERE target_id = ?", buildTableName)
+	return s.db.Exec(sql, id)
+}
+
+// deleteStaleBuilds deletes the build info of the target which ends before the safepoint, the profiles of them
+// are deleted. The latest build info is kept even if it's stale, since it's cached and extended in place by
+// UpdateProfileTargetBuild, it's deleted with the target.
+func (s *ProfileStorage) deleteStaleBuilds(pt meta.ProfileTarget, info meta.TargetInfo, safePointTs int64) error {
+	s.Lock()
+	var latest *meta.TargetBuild
+	if cacheInfo := s.metaCache[pt]; cacheInfo != nil && cacheInfo.ID == info.ID {
+		latest = cacheInfo.Build
+	}
+	s.Unlock()
+	if latest == nil {
+		return nil
+	}
+	// the cached latest build is never newer than the one in the table, the rows since it are kept.
+	sql := fmt.Sprintf("DELETE FROM %v WHERE target_id = ? AND end_ts <= ? AND begin_ts < ?", buildTableName)
+	return s.db.Exec(sql, info.ID, safePointTs, latest.BeginTs)
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/stretchr/testify/require"
+)
+
+func queryBuilds(t *testing.T, s *ProfileStorage, pt meta.ProfileTarget, begin, end int64) []meta.TargetBuild {
+	lists, err := s.QueryProfileList(&meta.BasicQueryParam{Begin: begin, End: end, Targets: []meta.ProfileTarget{pt}})
+	require.NoError(t, err)
+	require.Len(t, lists, 1)
+	return lists[0].Builds
+}
+
+func TestUpdateProfileTargetBuild(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStorage(t, dir)
+	pt := meta.ProfileTarget{Kind: "profile", Component: "tidb", Address: "10.0.1.1:10080"}
+	v1 := meta.BuildInfo{Version: "v5.2.0", GitHash: "a1", StartTime: 90}
+	v2 := meta.BuildInfo{Version: "v5.3.0", GitHash: "b2", StartTime: 250}
+
+	// the target has no table yet.
+	updated, err := s.UpdateProfileTargetBuild(pt, v1, 100)
+	require.NoError(t, err)
+	require.False(t, updated)
+	require.NoError(t, s.AddProfile(pt, 100, []byte("p1"), meta.ProfileAttr{}))
+	updated, err = s.UpdateProfileTargetBuild(pt, meta.BuildInfo{}, 100)
+	require.NoError(t, err)
+	require.False(t, updated)
+
+	updated, err = s.UpdateProfileTargetBuild(pt, v1, 100)
+	require.NoError(t, err)
+	require.True(t, updated)
+	// the same build extends the current range, the older ts is ignored.
+	updated, err = s.UpdateProfileTargetBuild(pt, v1, 90)
+	require.NoError(t, err)
+	require.False(t, updated)
+	updated, err = s.UpdateProfileTargetBuild(pt, v1, 200)
+	require.NoError(t, err)
+	require.True(t, updated)
+	require.Equal(t, []meta.TargetBuild{{BuildInfo: v1, BeginTs: 100, EndTs: 200}}, queryBuilds(t, s, pt, 0, 1000))
+
+	// the new build older than the current range is ignored.
+	updated, err = s.UpdateProfileTargetBuild(pt, v2, 150)
+	require.NoError(t, err)
+	require.False(t, updated)
+	// the new build starts a new range.
+	updated, err = s.UpdateProfileTargetBuild(pt, v2, 300)
+	require.NoError(t, err)
+	require.True(t, updated)
+	require.Equal(t, []meta.TargetBuild{
+		{BuildInfo: v1, BeginTs: 100, EndTs: 200},
+		{BuildInfo: v2, BeginTs: 300, EndTs: 300},
+	}, queryBuilds(t, s, pt, 0, 1000))
+	// only the ranges overlapping the query are returned.
+	require.Equal(t, []meta.TargetBuild{{BuildInfo: v1, BeginTs: 100, EndTs: 200}}, queryBuilds(t, s, pt, 150, 250))
+	require.Len(t, queryBuilds(t, s, pt, 210, 290), 0)
+
+	// the latest build is reloaded after restart.
+	require.NoError(t, s.Close())
+	s = newTestStorage(t, dir)
+	defer s.Close()
+	updated, err = s.UpdateProfileTargetBuild(pt, v2, 400)
+	require.NoError(t, err)
+	require.True(t, updated)
+	require.Equal(t, []meta.TargetBuild{
+		{BuildInfo: v1, BeginTs: 100, EndTs: 200},
+		{BuildInfo: v2, BeginTs: 300, EndTs: 400},
+	}, queryBuilds(t, s, pt, 0, 1000))
+}
+
+func TestDeleteStaleBuilds(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+	defer s.Close()
+	pt := meta.ProfileTarget{Kind: "profile", Component: "tidb", Address: "10.0.1.1:10080"}
+	v1 := meta.BuildInfo{Version: "v5.2.0", GitHash: "a1", StartTime: 90}
+	v2 := meta.BuildInfo{Version: "v5.3.0", GitHash: "b2", StartTime: 250}
+	require.NoError(t, s.AddProfile(pt, 100, []byte("p1"), meta.ProfileAttr{}))
+	for _, update := range []struct {
+		build meta.BuildInfo
+		ts    int64
+	}{{v1, 100}, {v1, 200}, {v2, 300}} {
+		_, err := s.UpdateProfileTargetBuild(pt, update.build, update.ts)
+		require.NoError(t, err)
+	}
+	info := s.getTargetInfoFromCache(pt)
+
+	// the stale latest build is kept, so the later update still extends it.
+	require.NoError(t, s.deleteStaleBuilds(pt, *info, 350))
+	require.Equal(t, []meta.TargetBuild{{BuildInfo: v2, BeginTs: 300, EndTs: 300}}, queryBuilds(t, s, pt, 0, 1000))
+	updated, err := s.UpdateProfileTargetBuild(pt, v2, 400)
+	require.NoError(t, err)
+	require.True(t, updated)
+	require.Equal(t, []meta.TargetBuild{{BuildInfo: v2, BeginTs: 300, EndTs: 400}}, queryBuilds(t, s, pt, 0, 1000))
+}
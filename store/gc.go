@@ -6,6 +6,7 @@ import (
 
 	"github.com/crazycs520/continuous-profile/config"
 	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/crazycs520/continuous-profile/metrics"
 	"github.com/crazycs520/continuous-profile/util"
 	"github.com/genjidb/genji/document"
 	"github.com/genjidb/genji/types"
@@ -37,24 +38,37 @@ func (s *ProfileStorage) runGC() {
 	safePointTs := s.getLastSafePointTs()
 	for i, target := range allTargets {
 		info := allInfos[i]
+		// the deleted rows are not counted, genji doesn't report them and an extra COUNT(*) per table is costly.
 		sql := fmt.Sprintf("DELETE FROM %v WHERE ts <= ?", s.getProfileTableName(&info))
 		err := s.db.Exec(sql, safePointTs)
 		if err != nil {
 			log.Error("gc delete target data failed", zap.Error(err))
 		}
+		err = s.deleteStaleBuilds(target, info, safePointTs)
+		if err != nil {
+			log.Error("gc delete stale build info failed", zap.Error(err))
+		}
 		err = s.dropProfileTableIfStaled(target, info, safePointTs)
 		if err != nil {
 			log.Error("gc drop target table failed", zap.Error(err))
 		}
 	}
+	s.updateBadgerSizeMetrics()
+	metrics.GCDuration.Observe(time.Since(start).Seconds())
 	log.Info("gc finished",
 		zap.Int("total-targets", len(allTargets)),
 		zap.Int64("safepoint", safePointTs),
 		zap.Duration("cost", time.Since(start)))
 }
 
+func (s *ProfileStorage) updateBadgerSizeMetrics() {
+	lsm, vlog := s.badgerDB.Size()
+	metrics.BadgerSizeGauge.WithLabelValues("lsm").Set(float64(lsm))
+	metrics.BadgerSizeGauge.WithLabelValues("vlog").Set(float64(vlog))
+}
+
 func (s *ProfileStorage) loadAllTargetsFromTable() ([]meta.ProfileTarget, []meta.TargetInfo, error) {
-	query := fmt.Sprintf("SELECT id, kind, component, address, last_scrape_ts FROM %v", metaTableName)
+	query := fmt.Sprintf("SELECT id, kind, component, address, last_scrape_ts, labels, instance FROM %v", metaTableName)
 	res, err := s.db.Query(query)
 	if err != nil {
 		return nil, nil, err
@@ -65,8 +79,12 @@ func (s *ProfileStorage) loadAllTargetsFromTable() ([]meta.ProfileTarget, []meta
 	infos := make([]meta.TargetInfo, 0, 16)
 	err = res.Iterate(func(d types.Document) error {
 		var id, ts int64
-		var kind, component, address string
-		err = document.Scan(d, &id, &kind, &component, &address, &ts)
+		var kind, component, address, labels, instance string
+		err = document.Scan(d, &id, &kind, &component, &address, &ts, &labels, &instance)
+		if err != nil {
+			return err
+		}
+		targetLabels, err := decodeLabels(labels)
 		if err != nil {
 			return err
 		}
@@ -79,11 +97,16 @@ func (s *ProfileStorage) loadAllTargetsFromTable() ([]meta.ProfileTarget, []meta
 		info := meta.TargetInfo{
 			ID:           id,
 			LastScrapeTs: ts,
+			Labels:       targetLabels,
+			Instance:     instance,
 		}
 		targets = append(targets, target)
 		infos = append(infos, info)
 		return nil
 	})
+	if err != nil {
+		return nil, nil, err
+	}
 	log.Info("gc load all target info from meta table",
 		zap.Int("all-target-count", len(targets)))
 	return targets, infos, nil
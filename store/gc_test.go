@@ -0,0 +1,43 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/crazycs520/continuous-profile/metrics"
+	"github.com/crazycs520/continuous-profile/util"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreMetrics(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+	defer s.Close()
+	pt := meta.ProfileTarget{Kind: "profile", Component: "tidb", Address: "10.0.1.1:10080"}
+	now := util.GetTimeStamp(time.Now())
+	bytesCounter := metrics.StoreBytesCounter.WithLabelValues(pt.Component, pt.Kind)
+	storedBytes := testutil.ToFloat64(bytesCounter)
+	for _, ts := range []int64{now - 7200, now - 7100, now} {
+		require.NoError(t, s.AddProfile(pt, ts, []byte("p1"), meta.ProfileAttr{}))
+	}
+	require.Equal(t, storedBytes+6, testutil.ToFloat64(bytesCounter))
+	droppedTables := testutil.ToFloat64(metrics.GCDroppedTablesCounter)
+
+	// the profiles older than the data retention are deleted.
+	cfg := config.NewConfig()
+	cfg.ContinueProfiling.DataRetentionSeconds = 3600
+	config.StoreGlobalConfig(cfg)
+	s.runGC()
+	require.Equal(t, droppedTables, testutil.ToFloat64(metrics.GCDroppedTablesCounter))
+	require.Equal(t, []int64{now}, queryTsList(t, s, &meta.BasicQueryParam{Targets: []meta.ProfileTarget{pt}})[0].TsList)
+	// the sizes of the lsm tree and the value log.
+	require.Equal(t, 2, testutil.CollectAndCount(metrics.BadgerSizeGauge))
+
+	// the stale target is dropped.
+	cfg.ContinueProfiling.DataRetentionSeconds = -3600
+	s.runGC()
+	require.Equal(t, droppedTables+1, testutil.ToFloat64(metrics.GCDroppedTablesCounter))
+	require.Nil(t, s.getTargetInfoFromCache(pt))
+}
@@ -0,0 +1,202 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/crazycs520/continuous-profile/util"
+	"github.com/genjidb/genji/document"
+	"github.com/genjidb/genji/types"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+const aliasTableName = tableNamePrefix + "_targets_alias"
+
+// instanceKey identifies the profiles of an instance, the instance identity is only unique in the component.
+type instanceKey struct {
+	kind      string
+	component string
+	instance  string
+}
+
+func newInstanceKey(pt meta.ProfileTarget, instance string) instanceKey {
+	return instanceKey{kind: pt.Kind, component: pt.Component, instance: instance}
+}
+
+func (s *ProfileStorage) initAliasTable() error {
+	sql := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v (target_id INTEGER, address TEXT, end_ts INTEGER)", aliasTableName)
+	return s.db.Exec(sql)
+}
+
+func (s *ProfileStorage) loadAliasesIntoCache(targets []meta.ProfileTarget, infos []meta.TargetInfo) error {
+	idToTarget := make(map[int64]meta.ProfileTarget, len(targets))
+	for i, pt := range targets {
+		idToTarget[infos[i].ID] = pt
+	}
+	query := fmt.Sprintf("SELECT target_id, address, end_ts FROM %v ORDER BY end_ts", aliasTableName)
+	res, err := s.db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	return res.Iterate(func(d types.Document) error {
+		var id int64
+		var alias meta.TargetAlias
+		err := document.Scan(d, &id, &alias.Address, &alias.EndTs)
+		if err != nil {
+			return err
+		}
+		pt, ok := idToTarget[id]
+		if !ok {
+			return nil
+		}
+		info := s.metaCache[pt]
+		info.Aliases = append(info.Aliases, alias)
+		s.aliasCache[meta.ProfileTarget{Kind: pt.Kind, Component: pt.Component, Address: alias.Address}] = pt
+		return nil
+	})
+}
+
+// BindProfileTargetInstance binds the target to the stable instance identity, such as the TiKV store ID, it
+// should be called before storing the profiles of the target. If the instance was bound to another address,
+// the profile table of the instance is moved to the new address and the previous address becomes an alias,
+// so the history of the instance is kept in one table.
+func (s *ProfileStorage) BindProfileTargetInstance(pt meta.ProfileTarget, instance string) error {
+	if s.isClose() {
+		return ErrStoreIsClosed
+	}
+	if instance == "" {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	key := newInstanceKey(pt, instance)
+	owner, bound := s.instanceCache[key]
+	if bound && owner == pt {
+		return nil
+	}
+	info := s.metaCache[pt]
+	if info == nil {
+		err := s.loadMetaIntoCache(pt)
+		if err != nil {
+			return err
+		}
+		info = s.metaCache[pt]
+	}
+	if bound {
+		if info != nil {
+			// both addresses have their own tables, such as the tables created by the old version.
+			log.Warn("the instance is bound to another target, keep the separate history",
+				zap.String("component", pt.Component),
+				zap.String("address", pt.Address),
+				zap.String("kind", pt.Kind),
+				zap.String("instance", instance),
+				zap.String("bound-address", owner.Address))
+			return nil
+		}
+		return s.moveTargetAddress(owner, pt)
+	}
+
+	if info == nil {
+		var err error
+		info, err = s.createProfileTable(pt)
+		if err != nil {
+			return err
+		}
+		s.metaCache[pt] = info
+		// another instance takes the previous address, the address is not an alias any more.
+		delete(s.aliasCache, pt)
+	}
+	sql := fmt.Sprintf("UPDATE %v set instance = ? where id = ?", metaTableName)
+	err := s.db.Exec(sql, instance, info.ID)
+	if err != nil {
+		return err
+	}
+	// the instance identity may change at the same address, such as a new store is created at the address.
+	if info.Instance != "" {
+		delete(s.instanceCache, newInstanceKey(pt, info.Instance))
+	}
+	info.Instance = instance
+	s.instanceCache[key] = pt
+	return nil
+}
+
+// moveTargetAddress moves the target table of the instance from the previous address to the new address.
+func (s *ProfileStorage) moveTargetAddress(from, to meta.ProfileTarget) error {
+	info := s.metaCache[from]
+	if info == nil {
+		return fmt.Errorf("target %v %v %v is not found", from.Component, from.Address, from.Kind)
+	}
+	now := util.GetTimeStamp(time.Now())
+	// the new address may be a previous alias if the instance moves back.
+	sql := fmt.Sprintf("DELETE FROM %v WHERE target_id = ? AND (address = ? OR address = ?)", aliasTableName)
+	err := s.db.Exec(sql, info.ID, to.Address, from.Address)
+	if err != nil {
+		return err
+	}
+	sql = fmt.Sprintf("INSERT INTO %v (target_id, address, end_ts) VALUES (?, ?, ?)", aliasTableName)
+	err = s.db.Exec(sql, info.ID, from.Address, now)
+	if err != nil {
+		return err
+	}
+	sql = fmt.Sprintf("UPDATE %v set address = ? where id = ?", metaTableName)
+	err = s.db.Exec(sql, to.Address, info.ID)
+	if err != nil {
+		return err
+	}
+
+	aliases := make([]meta.TargetAlias, 0, len(info.Aliases)+1)
+	for _, alias := range info.Aliases {
+		if alias.Address != to.Address && alias.Address != from.Address {
+			aliases = append(aliases, alias)
+		}
+	}
+	info.Aliases = append(aliases, meta.TargetAlias{Address: from.Address, EndTs: now})
+	delete(s.metaCache, from)
+	s.metaCache[to] = info
+	delete(s.aliasCache, to)
+	for alias, cur := range s.aliasCache {
+		if cur == from {
+			s.aliasCache[alias] = to
+		}
+	}
+	s.aliasCache[from] = to
+	s.instanceCache[newInstanceKey(to, info.Instance)] = to
+	log.Info("move profile target to the new address",
+		zap.Int64("id", info.ID),
+		zap.String("component", to.Component),
+		zap.String("kind", to.Kind),
+		zap.String("instance", info.Instance),
+		zap.String("from", from.Address),
+		zap.String("to", to.Address))
+	return nil
+}
+
+// getTargetIdentity returns the instance and the aliases of the target.
+func (s *ProfileStorage) getTargetIdentity(info *meta.TargetInfo) (string, []meta.TargetAlias) {
+	s.Lock()
+	defer s.Unlock()
+	return info.Instance, info.Aliases
+}
+
+// deleteInstanceCache deletes the instance and aliases of the dropped target, it should be called with the lock.
+func (s *ProfileStorage) deleteInstanceCache(pt meta.ProfileTarget) {
+	for key, cur := range s.instanceCache {
+		if cur == pt {
+			delete(s.instanceCache, key)
+		}
+	}
+	for alias, cur := range s.aliasCache {
+		if cur == pt {
+			delete(s.aliasCache, alias)
+		}
+	}
+}
+
+func (s *ProfileStorage) deleteTargetAliases(id int64) error {
+	sql := fmt.Sprintf("DELETE FROM %v WHERE target_id = ?", aliasTableName)
+	return s.db.Exec(sql, id)
+}
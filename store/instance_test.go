@@ -0,0 +1,111 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/crazycs520/continuous-profile/util"
+	"github.com/genjidb/genji/document"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStorage(t *testing.T, dir string) *ProfileStorage {
+	config.StoreGlobalConfig(config.NewConfig())
+	s, err := NewProfileStorage(dir)
+	require.NoError(t, err)
+	return s
+}
+
+func queryTsList(t *testing.T, s *ProfileStorage, param *meta.BasicQueryParam) []meta.ProfileList {
+	param.Begin, param.End = 0, 1<<40
+	lists, err := s.QueryProfileList(param)
+	require.NoError(t, err)
+	return lists
+}
+
+func countAliases(t *testing.T, s *ProfileStorage) int64 {
+	d, err := s.db.QueryDocument(fmt.Sprintf("SELECT COUNT(*) FROM %v", aliasTableName))
+	require.NoError(t, err)
+	var count int64
+	require.NoError(t, document.Scan(d, &count))
+	return count
+}
+
+func TestBindProfileTargetInstance(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStorage(t, dir)
+	a := meta.ProfileTarget{Kind: "profile", Component: "tikv", Address: "10.0.1.1:20180"}
+	b := meta.ProfileTarget{Kind: "profile", Component: "tikv", Address: "10.0.1.2:20180"}
+
+	require.NoError(t, s.BindProfileTargetInstance(a, "store-1"))
+	require.NoError(t, s.AddProfile(a, 100, []byte("p1"), meta.ProfileAttr{}))
+
+	// the instance moves to b, the history of a is kept in the same table.
+	require.NoError(t, s.BindProfileTargetInstance(b, "store-1"))
+	require.NoError(t, s.AddProfile(b, 200, []byte("p2"), meta.ProfileAttr{}))
+	lists := queryTsList(t, s, &meta.BasicQueryParam{Instances: []string{"store-1"}})
+	require.Len(t, lists, 1)
+	require.Equal(t, b, lists[0].Target)
+	require.Equal(t, []int64{100, 200}, lists[0].TsList)
+	require.Len(t, lists[0].Aliases, 1)
+	require.Equal(t, a.Address, lists[0].Aliases[0].Address)
+
+	// the scrape suite of the previous address may still write before it's reloaded.
+	require.NoError(t, s.AddProfile(a, 300, []byte("p3"), meta.ProfileAttr{}))
+	lastScrapeTs := util.GetTimeStamp(time.Now()) + 10
+	updated, err := s.UpdateProfileTargetInfo(a, lastScrapeTs, nil)
+	require.NoError(t, err)
+	require.True(t, updated)
+	require.Equal(t, lastScrapeTs, s.getTargetInfoFromCache(b).LastScrapeTs)
+	lists = queryTsList(t, s, &meta.BasicQueryParam{Targets: []meta.ProfileTarget{a}})
+	require.Len(t, lists, 1)
+	require.Equal(t, []int64{100, 200, 300}, lists[0].TsList)
+	require.Len(t, s.getAllTargetsFromCache(), 1)
+
+	// the instance moves back to a.
+	require.NoError(t, s.BindProfileTargetInstance(a, "store-1"))
+	lists = queryTsList(t, s, &meta.BasicQueryParam{Targets: []meta.ProfileTarget{b}})
+	require.Len(t, lists, 1)
+	require.Equal(t, []int64{100, 200, 300}, lists[0].TsList)
+	require.Equal(t, "store-1", lists[0].Instance)
+	require.Len(t, lists[0].Aliases, 1)
+	require.Equal(t, b.Address, lists[0].Aliases[0].Address)
+	require.Equal(t, []meta.ProfileTarget{a}, s.getAllTargetsFromCache())
+	require.Equal(t, int64(1), countAliases(t, s))
+
+	// the instance and aliases are reloaded after restart.
+	require.NoError(t, s.Close())
+	s = newTestStorage(t, dir)
+	defer s.Close()
+	lists = queryTsList(t, s, &meta.BasicQueryParam{Targets: []meta.ProfileTarget{b}})
+	require.Len(t, lists, 1)
+	require.Equal(t, []int64{100, 200, 300}, lists[0].TsList)
+	require.Equal(t, "store-1", lists[0].Instance)
+	require.NoError(t, s.BindProfileTargetInstance(a, "store-1"))
+	require.Equal(t, int64(1), countAliases(t, s))
+
+	// another instance takes the previous address.
+	require.NoError(t, s.BindProfileTargetInstance(b, "store-2"))
+	require.NoError(t, s.AddProfile(b, 400, []byte("p4"), meta.ProfileAttr{}))
+	lists = queryTsList(t, s, &meta.BasicQueryParam{Targets: []meta.ProfileTarget{b}})
+	require.Equal(t, "store-2", lists[0].Instance)
+	require.Equal(t, []int64{400}, lists[0].TsList)
+	lists = queryTsList(t, s, &meta.BasicQueryParam{Instances: []string{"store-1"}})
+	require.Len(t, lists, 1)
+	require.Equal(t, []int64{100, 200, 300}, lists[0].TsList)
+
+	// gc drops the moved target with its aliases.
+	cfg := config.NewConfig()
+	cfg.ContinueProfiling.DataRetentionSeconds = -3600
+	config.StoreGlobalConfig(cfg)
+	s.runGC()
+	require.Len(t, s.getAllTargetsFromCache(), 0)
+	require.Nil(t, s.getTargetInfoFromCache(a))
+	require.Nil(t, s.getTargetInfoFromCache(b))
+	require.Len(t, s.instanceCache, 0)
+	require.Len(t, s.aliasCache, 0)
+	require.Equal(t, int64(0), countAliases(t, s))
+}
@@ -2,12 +2,15 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/crazycs520/continuous-profile/metrics"
 	"github.com/crazycs520/continuous-profile/util"
 	"github.com/crazycs520/continuous-profile/util/logutil"
 	"github.com/dgraph-io/badger/v3"
@@ -15,6 +18,7 @@ import (
 	"github.com/genjidb/genji"
 	"github.com/genjidb/genji/document"
 	"github.com/genjidb/genji/engine/badgerengine"
+	genjierrors "github.com/genjidb/genji/errors"
 	"github.com/genjidb/genji/types"
 	"github.com/pingcap/log"
 	"go.uber.org/atomic"
@@ -26,15 +30,23 @@ const (
 	metaTableName   = tableNamePrefix + "_targets_meta"
 )
 
-var ErrStoreIsClosed = errors.New("storage is closed")
+var (
+	ErrStoreIsClosed = errors.New("storage is closed")
+	// ErrProfileExists is returned if the target already has a profile at the same timestamp.
+	ErrProfileExists = errors.New("profile already exists")
+)
 
 type ProfileStorage struct {
 	closed atomic.Bool
 	sync.Mutex
-	db           *genji.DB
-	metaCache    map[meta.ProfileTarget]*meta.TargetInfo
-	idAllocator  int64
-	aliveTargets []meta.ProfileTarget
+	db        *genji.DB
+	badgerDB  *badger.DB
+	metaCache map[meta.ProfileTarget]*meta.TargetInfo
+	// instanceCache is the current target of each instance, aliasCache is the current target of each alias.
+	instanceCache map[instanceKey]meta.ProfileTarget
+	aliasCache    map[meta.ProfileTarget]meta.ProfileTarget
+	idAllocator   int64
+	aliveTargets  []meta.ProfileTarget
 }
 
 func NewProfileStorage(storagePath string) (*ProfileStorage, error) {
@@ -53,8 +65,11 @@ func NewProfileStorage(storagePath string) (*ProfileStorage, error) {
 		return nil, err
 	}
 	store := &ProfileStorage{
-		db:        db,
-		metaCache: make(map[meta.ProfileTarget]*meta.TargetInfo),
+		db:            db,
+		badgerDB:      ng.DB,
+		metaCache:     make(map[meta.ProfileTarget]*meta.TargetInfo),
+		instanceCache: make(map[instanceKey]meta.ProfileTarget),
+		aliasCache:    make(map[meta.ProfileTarget]meta.ProfileTarget),
 	}
 	err = store.init()
 	if err != nil {
@@ -71,22 +86,74 @@ func (s *ProfileStorage) init() error {
 	if err != nil {
 		return err
 	}
+	err = s.initBuildTable()
+	if err != nil {
+		return err
+	}
+	err = s.initAliasTable()
+	if err != nil {
+		return err
+	}
 	allTargets, allInfos, err := s.loadAllTargetsFromTable()
+	if err != nil {
+		return err
+	}
 	for i, target := range allTargets {
 		info := allInfos[i]
+		info.Build, err = s.loadLatestBuild(info.ID)
+		if err != nil {
+			return err
+		}
 		s.metaCache[target] = &info
+		if info.Instance != "" {
+			s.instanceCache[newInstanceKey(target, info.Instance)] = target
+		}
 	}
-	return nil
+	return s.loadAliasesIntoCache(allTargets, allInfos)
 }
 
 func (s *ProfileStorage) initMetaTable() error {
 	// create meta table if not exists.
-	sql := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v (id INTEGER primary key, kind TEXT, component TEXT, address TEXT, last_scrape_ts INTEGER)", metaTableName)
-	return s.db.Exec(sql)
+	sql := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v (id INTEGER primary key, kind TEXT, component TEXT, address TEXT, last_scrape_ts INTEGER, labels TEXT, instance TEXT)", metaTableName)
+	err := s.db.Exec(sql)
+	if err != nil {
+		return err
+	}
+	// the meta table created by the old version has no labels and instance fields, they are empty for the
+	// existing targets.
+	err = s.addFieldIfNotExists(metaTableName, "labels", "TEXT")
+	if err != nil {
+		return err
+	}
+	return s.addFieldIfNotExists(metaTableName, "instance", "TEXT")
+}
+
+// addFieldIfNotExists adds the field into the table created by the old version.
+func (s *ProfileStorage) addFieldIfNotExists(table, field, tp string) error {
+	d, err := s.db.QueryDocument("SELECT sql FROM __genji_catalog WHERE name = ?", table)
+	if err != nil {
+		return err
+	}
+	var createSQL string
+	err = document.Scan(d, &createSQL)
+	if err != nil {
+		return err
+	}
+	for _, def := range strings.Split(createSQL[strings.Index(createSQL, "(")+1:], ",") {
+		if strings.HasPrefix(strings.TrimSpace(def), field+" ") {
+			return nil
+		}
+	}
+	err = s.db.Exec(fmt.Sprintf("ALTER TABLE %v ADD FIELD %v %v", table, field, tp))
+	if err != nil {
+		return err
+	}
+	log.Info("add field into table", zap.String("table", table), zap.String("field", field))
+	return nil
 }
 
 func (s *ProfileStorage) loadMetaIntoCache(target meta.ProfileTarget) error {
-	query := fmt.Sprintf("SELECT id, last_scrape_ts FROM %v WHERE kind = ? AND component = ? AND address = ?", metaTableName)
+	query := fmt.Sprintf("SELECT id, last_scrape_ts, labels, instance FROM %v WHERE kind = ? AND component = ? AND address = ?", metaTableName)
 	res, err := s.db.Query(query, target.Kind, target.Component, target.Address)
 	if err != nil {
 		return err
@@ -95,7 +162,16 @@ func (s *ProfileStorage) loadMetaIntoCache(target meta.ProfileTarget) error {
 
 	err = res.Iterate(func(d types.Document) error {
 		var id, ts int64
-		err = document.Scan(d, &id, &ts)
+		var labels, instance string
+		err = document.Scan(d, &id, &ts, &labels, &instance)
+		if err != nil {
+			return err
+		}
+		targetLabels, err := decodeLabels(labels)
+		if err != nil {
+			return err
+		}
+		build, err := s.loadLatestBuild(id)
 		if err != nil {
 			return err
 		}
@@ -103,6 +179,12 @@ func (s *ProfileStorage) loadMetaIntoCache(target meta.ProfileTarget) error {
 		s.metaCache[target] = &meta.TargetInfo{
 			ID:           id,
 			LastScrapeTs: ts,
+			Labels:       targetLabels,
+			Build:        build,
+			Instance:     instance,
+		}
+		if instance != "" {
+			s.instanceCache[newInstanceKey(target, instance)] = target
 		}
 		log.Info("load target info into cache",
 			zap.String("component", target.Component),
@@ -115,26 +197,51 @@ func (s *ProfileStorage) loadMetaIntoCache(target meta.ProfileTarget) error {
 	return err
 }
 
-func (s *ProfileStorage) UpdateProfileTargetInfo(pt meta.ProfileTarget, ts int64) (bool, error) {
+// UpdateProfileTargetInfo updates the last scrape ts and the labels of the target. The nil labels means keeping
+// the current labels. It returns true if the target info is updated.
+func (s *ProfileStorage) UpdateProfileTargetInfo(pt meta.ProfileTarget, ts int64, labels map[string]string) (bool, error) {
 	if s.isClose() {
 		return false, ErrStoreIsClosed
 	}
-	s.Lock()
-	info := s.metaCache[pt]
-	s.Unlock()
-	if info == nil || ts <= info.LastScrapeTs {
+	info := s.getTargetInfoFromCache(pt)
+	if info == nil {
 		return false, nil
 	}
-	info.LastScrapeTs = ts
-	sql := fmt.Sprintf("UPDATE %v set last_scrape_ts = ? where id = ?", metaTableName)
-	err := s.db.Exec(sql, ts, info.ID)
-	if err != nil {
-		return false, err
+	updated := false
+	if ts > info.LastScrapeTs {
+		info.LastScrapeTs = ts
+		sql := fmt.Sprintf("UPDATE %v set last_scrape_ts = ? where id = ?", metaTableName)
+		err := s.db.Exec(sql, ts, info.ID)
+		if err != nil {
+			return false, err
+		}
+		updated = true
+	}
+	if labels != nil && !labelsEqual(labels, s.getTargetLabels(info)) {
+		encoded, err := encodeLabels(labels)
+		if err != nil {
+			return updated, err
+		}
+		sql := fmt.Sprintf("UPDATE %v set labels = ? where id = ?", metaTableName)
+		err = s.db.Exec(sql, encoded, info.ID)
+		if err != nil {
+			return updated, err
+		}
+		s.Lock()
+		info.Labels = labels
+		s.Unlock()
+		updated = true
 	}
-	return true, nil
+	return updated, nil
 }
 
-func (s *ProfileStorage) AddProfile(pt meta.ProfileTarget, ts int64, profile []byte) error {
+func (s *ProfileStorage) getTargetLabels(info *meta.TargetInfo) map[string]string {
+	s.Lock()
+	defer s.Unlock()
+	return info.Labels
+}
+
+func (s *ProfileStorage) AddProfile(pt meta.ProfileTarget, ts int64, profile []byte, attr meta.ProfileAttr) error {
 	if s.isClose() {
 		return ErrStoreIsClosed
 	}
@@ -143,8 +250,22 @@ func (s *ProfileStorage) AddProfile(pt meta.ProfileTarget, ts int64, profile []b
 		return err
 	}
 
-	sql := fmt.Sprintf("INSERT INTO %v (ts, data) VALUES (?, ?)", s.getProfileTableName(info))
-	return s.db.Exec(sql, ts, profile)
+	start := time.Now()
+	labels, err := encodeLabels(attr.Labels)
+	if err != nil {
+		return err
+	}
+	sql := fmt.Sprintf("INSERT INTO %v (ts, data, format, tag, note, round, labels) VALUES (?, ?, ?, ?, ?, ?, ?)", s.getProfileTableName(info))
+	err = s.db.Exec(sql, ts, profile, attr.Format, attr.Tag, attr.Note, attr.Round, labels)
+	if errors.Is(err, genjierrors.ErrDuplicateDocument) {
+		return ErrProfileExists
+	}
+	if err != nil {
+		return err
+	}
+	metrics.StoreWriteDuration.Observe(time.Since(start).Seconds())
+	metrics.StoreBytesCounter.WithLabelValues(pt.Component, pt.Kind).Add(float64(len(profile)))
+	return nil
 }
 
 func (s *ProfileStorage) QueryProfileList(param *meta.BasicQueryParam) ([]meta.ProfileList, error) {
@@ -154,13 +275,10 @@ func (s *ProfileStorage) QueryProfileList(param *meta.BasicQueryParam) ([]meta.P
 	if param == nil {
 		return nil, nil
 	}
-	targets := param.Targets
-	if len(targets) == 0 {
-		targets = s.getAllTargetsFromCache()
-	}
+	targets := s.getQueryTargets(param)
 
 	var result []meta.ProfileList
-	args := []interface{}{param.Begin, param.End}
+	cond, args := s.buildQueryCondition(param)
 	for _, pt := range targets {
 		info := s.getTargetInfoFromCache(pt)
 		if info == nil {
@@ -169,20 +287,32 @@ func (s *ProfileStorage) QueryProfileList(param *meta.BasicQueryParam) ([]meta.P
 			})
 			continue
 		}
+		list, err := s.getTargetMeta(pt, info, param.Begin, param.End)
+		if err != nil {
+			return nil, err
+		}
 
-		query := fmt.Sprintf("SELECT ts FROM %v WHERE ts >= ? and ts <= ?", s.getProfileTableName(info))
+		query := fmt.Sprintf("SELECT ts, format, tag, note, round, labels FROM %v WHERE %v", s.getProfileTableName(info), cond)
 		res, err := s.db.Query(query, args...)
 		if err != nil {
 			return nil, err
 		}
 		var tsList []int64
+		var attrs []meta.ProfileAttr
 		err = res.Iterate(func(d types.Document) error {
 			var ts int64
-			err = document.Scan(d, &ts)
+			var attr meta.ProfileAttr
+			var labels string
+			err = document.Scan(d, &ts, &attr.Format, &attr.Tag, &attr.Note, &attr.Round, &labels)
+			if err != nil {
+				return err
+			}
+			attr.Labels, err = decodeLabels(labels)
 			if err != nil {
 				return err
 			}
 			tsList = append(tsList, ts)
+			attrs = append(attrs, attr)
 			return nil
 		})
 		if err != nil {
@@ -193,33 +323,57 @@ func (s *ProfileStorage) QueryProfileList(param *meta.BasicQueryParam) ([]meta.P
 		if err != nil {
 			return nil, err
 		}
-		result = append(result, meta.ProfileList{
-			Target: pt,
-			TsList: tsList,
-		})
+		list.TsList, list.Attrs = tsList, attrs
+		result = append(result, list)
 	}
 	return result, nil
 }
 
-func (s *ProfileStorage) QueryProfileData(param *meta.BasicQueryParam, handleFn func(meta.ProfileTarget, int64, []byte) error) error {
+// QueryProfileTargetMeta returns the labels, identity and the build info during [begin, end] of the target, the
+// profiles are not queried.
+func (s *ProfileStorage) QueryProfileTargetMeta(pt meta.ProfileTarget, begin, end int64) (meta.ProfileList, error) {
+	if s.isClose() {
+		return meta.ProfileList{}, ErrStoreIsClosed
+	}
+	info := s.getTargetInfoFromCache(pt)
+	if info == nil {
+		return meta.ProfileList{Target: pt}, nil
+	}
+	return s.getTargetMeta(pt, info, begin, end)
+}
+
+func (s *ProfileStorage) getTargetMeta(pt meta.ProfileTarget, info *meta.TargetInfo, begin, end int64) (meta.ProfileList, error) {
+	instance, aliases := s.getTargetIdentity(info)
+	builds, err := s.queryTargetBuilds(info, begin, end)
+	if err != nil {
+		return meta.ProfileList{}, err
+	}
+	return meta.ProfileList{
+		Target:   pt,
+		Labels:   s.getTargetLabels(info),
+		Builds:   builds,
+		Instance: instance,
+		Aliases:  aliases,
+	}, nil
+}
+
+func (s *ProfileStorage) QueryProfileData(param *meta.BasicQueryParam, handleFn func(meta.ProfileTarget, int64, meta.ProfileAttr, []byte) error) error {
 	if s.isClose() {
 		return ErrStoreIsClosed
 	}
 	if param == nil || handleFn == nil {
 		return nil
 	}
-	targets := param.Targets
-	if len(targets) == 0 {
-		targets = s.getAllTargetsFromCache()
-	}
+	targets := s.getQueryTargets(param)
 
-	args := []interface{}{param.Begin, param.End}
+	cond, args := s.buildQueryCondition(param)
 	for _, pt := range targets {
 		info := s.getTargetInfoFromCache(pt)
 		if info == nil {
 			continue
 		}
-		query := fmt.Sprintf("SELECT ts, data FROM %v WHERE ts >= ? and ts <= ?", s.getProfileTableName(info))
+		// the attributes are empty if the profile is stored by the old version.
+		query := fmt.Sprintf("SELECT ts, data, format, tag, note, round, labels FROM %v WHERE %v", s.getProfileTableName(info), cond)
 		res, err := s.db.Query(query, args...)
 		if err != nil {
 			return err
@@ -227,11 +381,17 @@ func (s *ProfileStorage) QueryProfileData(param *meta.BasicQueryParam, handleFn
 		err = res.Iterate(func(d types.Document) error {
 			var ts int64
 			var data []byte
-			err = document.Scan(d, &ts, &data)
+			var attr meta.ProfileAttr
+			var labels string
+			err = document.Scan(d, &ts, &data, &attr.Format, &attr.Tag, &attr.Note, &attr.Round, &labels)
 			if err != nil {
 				return err
 			}
-			return handleFn(pt, ts, data)
+			attr.Labels, err = decodeLabels(labels)
+			if err != nil {
+				return err
+			}
+			return handleFn(pt, ts, attr, data)
 		})
 		if err != nil {
 			res.Close()
@@ -245,10 +405,60 @@ func (s *ProfileStorage) QueryProfileData(param *meta.BasicQueryParam, handleFn
 	return nil
 }
 
+func (s *ProfileStorage) buildQueryCondition(param *meta.BasicQueryParam) (string, []interface{}) {
+	cond := "ts >= ? and ts <= ?"
+	args := []interface{}{param.Begin, param.End}
+	if param.Tag != "" {
+		cond += " and tag = ?"
+		args = append(args, param.Tag)
+	}
+	if param.Round > 0 {
+		cond += " and round = ?"
+		args = append(args, param.Round)
+	}
+	return cond, args
+}
+
+// getQueryTargets returns the targets of the query param, it's all the targets in cache if the param has no target.
+// The label matchers of the param should be compiled.
+func (s *ProfileStorage) getQueryTargets(param *meta.BasicQueryParam) []meta.ProfileTarget {
+	targets := param.Targets
+	if len(targets) == 0 {
+		targets = s.getAllTargetsFromCache()
+	}
+	if len(param.Kinds) == 0 && len(param.LabelMatchers) == 0 && len(param.Instances) == 0 {
+		return targets
+	}
+	filtered := make([]meta.ProfileTarget, 0, len(targets))
+	for _, pt := range targets {
+		if !param.MatchKind(pt.Kind) {
+			continue
+		}
+		var labels map[string]string
+		var instance string
+		if info := s.getTargetInfoFromCache(pt); info != nil {
+			labels = s.getTargetLabels(info)
+			instance, _ = s.getTargetIdentity(info)
+		}
+		if !param.MatchLabels(labels) || !param.MatchInstance(instance) {
+			continue
+		}
+		filtered = append(filtered, pt)
+	}
+	return filtered
+}
+
+// getTargetInfoFromCache returns the target info, the previous address of an instance returns the info of
+// its current address, which contains the full history.
 func (s *ProfileStorage) getTargetInfoFromCache(pt meta.ProfileTarget) *meta.TargetInfo {
 	s.Lock()
+	defer s.Unlock()
 	info := s.metaCache[pt]
-	s.Unlock()
+	if info == nil {
+		if cur, ok := s.aliasCache[pt]; ok {
+			info = s.metaCache[cur]
+		}
+	}
 	return info
 }
 
@@ -281,6 +491,11 @@ func (s *ProfileStorage) prepareProfileTable(pt meta.ProfileTarget) (*meta.Targe
 	if info != nil {
 		return info, nil
 	}
+	// the profiles of the previous address of an instance are stored into the table of the instance, such as
+	// the profile scraped from the previous address before the scrape suite is reloaded.
+	if cur, ok := s.aliasCache[pt]; ok {
+		return s.metaCache[cur], nil
+	}
 	err := s.loadMetaIntoCache(pt)
 	if err != nil {
 		return nil, err
@@ -304,7 +519,7 @@ func (s *ProfileStorage) createProfileTable(pt meta.ProfileTarget) (*meta.Target
 		LastScrapeTs: util.GetTimeStamp(time.Now()),
 	}
 	tbName := s.getProfileTableName(info)
-	sql := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v (ts INTEGER PRIMARY KEY, data BLOB)", tbName)
+	sql := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v (ts INTEGER PRIMARY KEY, data BLOB, format TEXT, tag TEXT, note TEXT, round INTEGER, labels TEXT)", tbName)
 	err := s.db.Exec(sql)
 	if err != nil {
 		return info, err
@@ -351,6 +566,16 @@ func (s *ProfileStorage) dropProfileTableIfStaled(pt meta.ProfileTarget, info me
 
 	// update cache
 	delete(s.metaCache, pt)
+	s.deleteInstanceCache(pt)
+
+	err = s.deleteTargetBuilds(info.ID)
+	if err != nil {
+		return err
+	}
+	err = s.deleteTargetAliases(info.ID)
+	if err != nil {
+		return err
+	}
 
 	// drop the table
 	sql = fmt.Sprintf("DROP TABLE IF EXISTS %v", s.getProfileTableName(&info))
@@ -358,6 +583,7 @@ func (s *ProfileStorage) dropProfileTableIfStaled(pt meta.ProfileTarget, info me
 	if err != nil {
 		return err
 	}
+	metrics.GCDroppedTablesCounter.Inc()
 	log.Info("drop profile target table",
 		zap.Int64("id", info.ID),
 		zap.String("component", pt.Component),
@@ -381,3 +607,36 @@ func (s *ProfileStorage) allocID() int64 {
 	s.idAllocator += 1
 	return s.idAllocator
 }
+
+// encodeLabels encodes the labels into json, the empty labels is encoded into empty string.
+func encodeLabels(labels map[string]string) (string, error) {
+	if len(labels) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func labelsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeLabels(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var labels map[string]string
+	err := json.Unmarshal([]byte(s), &labels)
+	return labels, err
+}
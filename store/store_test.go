@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/genjidb/genji"
+	"github.com/genjidb/genji/document"
+	"github.com/genjidb/genji/engine/badgerengine"
+	"github.com/stretchr/testify/require"
+)
+
+// createOldStorage creates the meta table and a profile table in the schema of the old version, which has
+// no labels and instance fields in the meta table and no attribute fields in the profile table.
+func createOldStorage(t *testing.T, dir string, pt meta.ProfileTarget) {
+	ng, err := badgerengine.NewEngine(badger.DefaultOptions(dir).WithLogger(nil))
+	require.NoError(t, err)
+	db, err := genji.New(context.Background(), ng)
+	require.NoError(t, err)
+	require.NoError(t, db.Exec(fmt.Sprintf("CREATE TABLE %v (id INTEGER primary key, kind TEXT, component TEXT, address TEXT, last_scrape_ts INTEGER)", metaTableName)))
+	require.NoError(t, db.Exec(fmt.Sprintf("INSERT INTO %v (id, kind, component, address, last_scrape_ts) VALUES (?, ?, ?, ?, ?)", metaTableName),
+		1, pt.Kind, pt.Component, pt.Address, 100))
+	require.NoError(t, db.Exec(fmt.Sprintf("CREATE TABLE `%v_1` (ts INTEGER PRIMARY KEY, data BLOB)", tableNamePrefix)))
+	require.NoError(t, db.Exec(fmt.Sprintf("INSERT INTO `%v_1` (ts, data) VALUES (?, ?)", tableNamePrefix), 100, []byte("p1")))
+	require.NoError(t, db.Close())
+}
+
+func getCreateTableSQL(t *testing.T, s *ProfileStorage, table string) string {
+	d, err := s.db.QueryDocument("SELECT sql FROM __genji_catalog WHERE name = ?", table)
+	require.NoError(t, err)
+	var sql string
+	require.NoError(t, document.Scan(d, &sql))
+	return sql
+}
+
+func TestUpgradeMetaTable(t *testing.T) {
+	dir := t.TempDir()
+	pt := meta.ProfileTarget{Kind: "profile", Component: "tikv", Address: "10.0.1.1:20180"}
+	createOldStorage(t, dir, pt)
+
+	for i := 0; i < 3; i++ {
+		s := newTestStorage(t, dir)
+		// the fields are added once, and the existing target is loaded.
+		sql := getCreateTableSQL(t, s, metaTableName)
+		for _, field := range []string{"labels", "instance"} {
+			require.Equal(t, 1, strings.Count(sql, field+" "), sql)
+		}
+		info := s.getTargetInfoFromCache(pt)
+		require.NotNil(t, info)
+		require.Equal(t, int64(1), info.ID)
+
+		lists := queryTsList(t, s, &meta.BasicQueryParam{Targets: []meta.ProfileTarget{pt}})
+		require.Len(t, lists, 1)
+		require.Equal(t, []int64{100}, lists[0].TsList[:1])
+		if i == 0 {
+			require.Nil(t, lists[0].Labels)
+			require.Equal(t, "", lists[0].Instance)
+			require.NoError(t, s.AddProfile(pt, 200, []byte("p2"), meta.ProfileAttr{Format: meta.ProfileFormatProtobuf}))
+			_, err := s.UpdateProfileTargetInfo(pt, 200, map[string]string{"zone": "z1"})
+			require.NoError(t, err)
+			require.NoError(t, s.BindProfileTargetInstance(pt, "store-1"))
+		} else {
+			// the values of the added fields are persisted.
+			require.Equal(t, map[string]string{"zone": "z1"}, lists[0].Labels)
+			require.Equal(t, "store-1", lists[0].Instance)
+			require.Equal(t, []int64{100, 200}, lists[0].TsList)
+			require.Equal(t, meta.ProfileFormatProtobuf, lists[0].Attrs[1].Format)
+		}
+		require.NoError(t, s.Close())
+	}
+}
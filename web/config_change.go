@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"reflect"
 
 	"github.com/crazycs520/continuous-profile/config"
 	"github.com/pingcap/log"
@@ -25,7 +26,7 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 	err := s.handleConfigModify(w, r)
 	if err != nil {
 		log.Info("handle config modify failed", zap.Error(err))
-		serveError(w, http.StatusBadRequest, "parse query param error: "+err.Error())
+		serveError(w, http.StatusBadRequest, "modify config failed: "+err.Error())
 		return
 	}
 }
@@ -67,10 +68,10 @@ func (s *Server) handleContinueProfilingConfigModify(w http.ResponseWriter, reqN
 		if !ok {
 			return fmt.Errorf("unknow config `%v`", k)
 		}
-		if oldValue == newValue {
+		if reflect.DeepEqual(oldValue, newValue) {
 			continue
 		}
-		currentNested[k] = newValue
+		currentNested[k] = mergeConfigValue(oldValue, newValue)
 		log.Info("handle continuous profiling config modify",
 			zap.String("name", k),
 			zap.Reflect("old-value", oldValue),
@@ -78,7 +79,7 @@ func (s *Server) handleContinueProfilingConfigModify(w http.ResponseWriter, reqN
 	}
 
 	data, err := json.Marshal(currentNested)
-	if err != err {
+	if err != nil {
 		return err
 	}
 	var newCfg config.ContinueProfilingConfig
@@ -87,9 +88,36 @@ func (s *Server) handleContinueProfilingConfigModify(w http.ResponseWriter, reqN
 		return err
 	}
 
-	cfg.ContinueProfiling = newCfg
-	config.StoreGlobalConfig(cfg)
+	newConf := *cfg
+	newConf.ContinueProfiling = newCfg
+	err = newConf.Validate()
+	if err != nil {
+		return err
+	}
+	config.StoreGlobalConfig(&newConf)
 	s.scraper.NotifyReload()
 	writeData(w, "success!")
 	return nil
 }
+
+// mergeConfigValue merges the new value into the old value. The objects are merged by key recursively, so
+// modifying a component in `components` keeps the overrides of the other components, and a key whose new
+// value is null is removed. The other values are replaced.
+func mergeConfigValue(oldValue, newValue interface{}) interface{} {
+	oldMap, ok := oldValue.(map[string]interface{})
+	if !ok {
+		return newValue
+	}
+	newMap, ok := newValue.(map[string]interface{})
+	if !ok {
+		return newValue
+	}
+	for k, v := range newMap {
+		if v == nil {
+			delete(oldMap, k)
+			continue
+		}
+		oldMap[k] = mergeConfigValue(oldMap[k], v)
+	}
+	return oldMap
+}
@@ -0,0 +1,66 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/scrape"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T, cfg *config.Config) *Server {
+	config.StoreGlobalConfig(cfg)
+	return &Server{scraper: scrape.NewManager(nil, nil)}
+}
+
+func postConfig(s *Server, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/config", strings.NewReader(body))
+	s.handleConfig(w, r)
+	return w
+}
+
+func TestConfigModifyLocalSource(t *testing.T) {
+	s := newTestServer(t, config.NewConfig())
+	w := postConfig(s, `{"continuous_profiling":{"components":{"tidb":{"pprof_config":{"x":{"source":"exec","command":["sh","-c","id"]}}}}}}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "only allowed in the scrape_configs")
+
+	w = postConfig(s, `{"continuous_profiling":{"components":{"tidb":{"pprof_config":{"x":{"source":"file","dir":"/etc","pattern":"*"}}}}}}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Len(t, config.GetGlobalConfig().ContinueProfiling.Components, 0)
+}
+
+func TestConfigModifyComponents(t *testing.T) {
+	s := newTestServer(t, config.NewConfig())
+	w := postConfig(s, `{"continuous_profiling": {"components": {"tikv": {"profile_seconds":10}}}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = postConfig(s, `{"continuous_profiling": {"components": {"tidb": {"pprof_config": {"goroutine": {"enabled": false}}}}}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	components := config.GetGlobalConfig().ContinueProfiling.Components
+	require.Len(t, components, 2)
+	require.Equal(t, 10, components["tikv"].ProfileSeconds)
+	require.False(t, components["tidb"].PprofConfig["goroutine"].IsEnabled())
+
+	// the other fields and profile kinds of the component are kept.
+	w = postConfig(s, `{"continuous_profiling": {"components": {"tidb": {"profile_seconds": 20, "pprof_config": {"block": {"path": "/debug/pprof/block2"}}}}}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	components = config.GetGlobalConfig().ContinueProfiling.Components
+	require.Equal(t, 20, components["tidb"].ProfileSeconds)
+	require.False(t, components["tidb"].PprofConfig["goroutine"].IsEnabled())
+	require.Equal(t, "/debug/pprof/block2", components["tidb"].PprofConfig["block"].Path)
+	require.Equal(t, 10, components["tikv"].ProfileSeconds)
+
+	// null removes the override of the component.
+	w = postConfig(s, `{"continuous_profiling": {"components": {"tikv": null}}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	components = config.GetGlobalConfig().ContinueProfiling.Components
+	require.Len(t, components, 1)
+	require.Contains(t, components, "tidb")
+
+	w = postConfig(s, `{"continuous_profiling": {"unknown": 1}}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -12,6 +12,7 @@ import (
 	"net/http/pprof"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Server struct {
@@ -61,10 +62,15 @@ func (s *Server) createMux() *http.ServeMux {
 	router.HandleFunc("/continuous-profiling/list", s.handleQueryList)
 	router.HandleFunc("/continuous-profiling/download", s.handleDownload)
 	router.HandleFunc("/continuous-profiling/components", s.handleComponents)
+	router.HandleFunc("/continuous-profiling/targets", s.handleTargets)
+	router.HandleFunc("/continuous-profiling/capture", s.handleCapture)
+	router.HandleFunc("/continuous-profiling/burst", s.handleBurst)
+	router.HandleFunc("/continuous-profiling/ingest", s.handleIngest)
 	router.HandleFunc("/continuous-profiling/estimate_size", s.handleEstimateSize)
 
 	serverMux := http.NewServeMux()
 	serverMux.Handle("/", router)
+	serverMux.Handle("/metrics", promhttp.Handler())
 	serverMux.HandleFunc("/debug/pprof/", pprof.Index)
 	serverMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
 	serverMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
@@ -0,0 +1,112 @@
+package web
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/crazycs520/continuous-profile/scrape"
+	"github.com/crazycs520/continuous-profile/store"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// handleIngest stores the profile pushed by the application which can't be scraped. The profile is in the
+// body, and the component, address, kind, ts, version, git_hash, instance and label=key=value are in the query string.
+func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		break
+	default:
+		serveError(w, http.StatusBadRequest, "only support post")
+		return
+	}
+	cfg := config.GetGlobalConfig().Ingest
+	if !cfg.Enable {
+		serveError(w, http.StatusForbidden, "ingest is not enabled")
+		return
+	}
+	if !checkIngestToken(r, cfg.Tokens) {
+		serveError(w, http.StatusUnauthorized, "invalid ingest token")
+		return
+	}
+
+	// don't use r.FormValue since it consumes the form-encoded body.
+	query := r.URL.Query()
+	param := &scrape.IngestParam{
+		Target: meta.ProfileTarget{
+			Kind:      query.Get("kind"),
+			Component: query.Get("component"),
+			Address:   query.Get("address"),
+		},
+		Build: meta.BuildInfo{
+			Version: query.Get("version"),
+			GitHash: query.Get("git_hash"),
+		},
+		Instance: query.Get("instance"),
+	}
+	if value := query.Get("ts"); len(value) > 0 {
+		ts, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			serveError(w, http.StatusBadRequest, "params ts value is invalid, should be int")
+			return
+		}
+		param.Ts = ts
+	}
+	for _, label := range query["label"] {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			serveError(w, http.StatusBadRequest, "params label value is invalid, should be key=value")
+			return
+		}
+		if param.Labels == nil {
+			param.Labels = make(map[string]string)
+		}
+		param.Labels[kv[0]] = kv[1]
+	}
+
+	// limit the compressed body too, the decompressed profile is limited by the ingest.
+	body := http.MaxBytesReader(w, r.Body, int64(cfg.MaxSize))
+	ts, err := s.scraper.Ingest(param, body, cfg.MaxSize)
+	if err != nil {
+		log.Info("ingest profile failed",
+			zap.String("component", param.Target.Component),
+			zap.String("address", param.Target.Address),
+			zap.String("kind", param.Target.Kind),
+			zap.Error(err))
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, scrape.ErrProfileTooLarge), strings.Contains(err.Error(), errBodyTooLarge):
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(err, store.ErrProfileExists):
+			status = http.StatusConflict
+		case errors.Is(err, store.ErrStoreIsClosed):
+			status = http.StatusServiceUnavailable
+		}
+		serveError(w, status, "ingest profile error: "+err.Error())
+		return
+	}
+	writeData(w, ts)
+}
+
+// errBodyTooLarge is the error message of http.MaxBytesReader when the body exceeds the limit.
+const errBodyTooLarge = "request body too large"
+
+func checkIngestToken(r *http.Request, tokens []string) bool {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	token := []byte(strings.TrimPrefix(auth, prefix))
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/crazycs520/continuous-profile/scrape"
+	"github.com/crazycs520/continuous-profile/store"
+	"github.com/crazycs520/continuous-profile/util"
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func newIngestTestServer(t *testing.T) (*Server, *store.ProfileStorage) {
+	cfg := config.NewConfig()
+	cfg.Ingest.Enable = true
+	cfg.Ingest.Tokens = []string{"token1"}
+	cfg.Ingest.MaxSize = 1024
+	config.StoreGlobalConfig(cfg)
+	s, err := store.NewProfileStorage(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return &Server{scraper: scrape.NewManager(s, nil)}, s
+}
+
+func newTestProfile(t *testing.T) []byte {
+	fn := &profile.Function{ID: 1, Name: "main.main"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample:     []*profile.Sample{{Location: []*profile.Location{loc}, Value: []int64{1}}},
+		Location:   []*profile.Location{loc},
+		Function:   []*profile.Function{fn},
+	}
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, p.Write(buf))
+	return buf.Bytes()
+}
+
+func postIngest(s *Server, token, query string, body []byte) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/continuous-profiling/ingest?"+query, bytes.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	s.handleIngest(w, r)
+	return w
+}
+
+func TestIngest(t *testing.T) {
+	s, st := newIngestTestServer(t)
+	data := newTestProfile(t)
+	ts := util.GetTimeStamp(time.Now())
+	query := fmt.Sprintf("component=batch-job&address=10.0.1.30:0&kind=profile&ts=%v&label=job=daily&label=team=sql", ts)
+
+	// auth
+	w := postIngest(s, "", query, data)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	w = postIngest(s, "token2", query, data)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = postIngest(s, "token1", query, data)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Equal(t, fmt.Sprint(ts), strings.TrimSpace(w.Body.String()))
+	target := meta.ProfileTarget{Kind: "profile", Component: "batch-job", Address: "10.0.1.30:0"}
+	lists, err := st.QueryProfileList(&meta.BasicQueryParam{Begin: ts - 1, End: ts + 1, Targets: []meta.ProfileTarget{target}})
+	require.NoError(t, err)
+	require.Len(t, lists, 1)
+	require.Equal(t, []int64{ts}, lists[0].TsList)
+	require.Equal(t, map[string]string{"job": "daily", "team": "sql"}, lists[0].Labels)
+	require.Equal(t, meta.ProfileTagPush, lists[0].Attrs[0].Tag)
+
+	// the profile at the same ts exists.
+	w = postIngest(s, "token1", query, data)
+	require.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+
+	// size limit
+	w = postIngest(s, "token1", "component=batch-job&address=10.0.1.30:0&kind=profile", bytes.Repeat([]byte{'a'}, 2048))
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+
+	// corrupt or non-pprof body
+	w = postIngest(s, "token1", "component=batch-job&address=10.0.1.30:0&kind=profile", data[:len(data)/2])
+	require.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	w = postIngest(s, "token1", "component=batch-job&address=10.0.1.30:0&kind=profile", []byte("hello"))
+	require.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	require.Contains(t, w.Body.String(), "invalid profile")
+
+	// invalid params
+	for _, query := range []string{
+		"address=10.0.1.30:0&kind=profile",
+		"component=batch-job&address=x/../../../evil:80&kind=profile",
+		"component=batch-job&address=10.0.1.30&kind=profile",
+		"component=batch-job&address=10.0.1.30:http&kind=profile",
+		"component=../batch-job&address=10.0.1.30:0&kind=profile",
+		"component=batch-job&address=10.0.1.30:0&kind=pro%2Ffile",
+		"component=" + strings.Repeat("a", 65) + "&address=10.0.1.30:0&kind=profile",
+		"component=batch-job&address=10.0.1.30:0&kind=profile&label=job",
+		"component=batch-job&address=10.0.1.30:0&kind=profile&ts=abc",
+		// milliseconds
+		fmt.Sprintf("component=batch-job&address=10.0.1.30:0&kind=profile&ts=%v", ts*1000),
+		// future
+		fmt.Sprintf("component=batch-job&address=10.0.1.30:0&kind=profile&ts=%v", ts+3600),
+		// older than the data retention
+		fmt.Sprintf("component=batch-job&address=10.0.1.30:0&kind=profile&ts=%v", ts-int64(config.GetGlobalConfig().ContinueProfiling.DataRetentionSeconds)-3600),
+	} {
+		w = postIngest(s, "token1", query, data)
+		require.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	// disabled
+	cfg := *config.GetGlobalConfig()
+	cfg.Ingest.Enable = false
+	config.StoreGlobalConfig(&cfg)
+	w = postIngest(s, "token1", query, data)
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
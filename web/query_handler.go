@@ -6,11 +6,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/crazycs520/continuous-profile/config"
 	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/crazycs520/continuous-profile/scrape"
 	"github.com/pingcap/log"
 	"go.uber.org/zap"
 )
@@ -41,9 +44,62 @@ func (s *Server) handleQueryList(w http.ResponseWriter, r *http.Request) {
 		serveError(w, http.StatusInternalServerError, "query profile error: "+err.Error())
 		return
 	}
+	if param != nil && param.GroupBy == meta.GroupByRound {
+		writeData(w, groupProfileListByRound(result))
+		return
+	}
 	writeData(w, result)
 }
 
+// groupProfileListByRound groups the profiles by the aligned scrape round, the profiles which are not
+// scraped in aligned mode are in round 0.
+func groupProfileListByRound(lists []meta.ProfileList) []meta.ProfileRound {
+	groups := make(map[int64]map[meta.ProfileTarget]*meta.ProfileList)
+	for _, list := range lists {
+		for i, ts := range list.TsList {
+			var attr meta.ProfileAttr
+			if i < len(list.Attrs) {
+				attr = list.Attrs[i]
+			}
+			targets, ok := groups[attr.Round]
+			if !ok {
+				targets = make(map[meta.ProfileTarget]*meta.ProfileList)
+				groups[attr.Round] = targets
+			}
+			l, ok := targets[list.Target]
+			if !ok {
+				l = &meta.ProfileList{Target: list.Target, Labels: list.Labels}
+				targets[list.Target] = l
+			}
+			l.TsList = append(l.TsList, ts)
+			l.Attrs = append(l.Attrs, attr)
+		}
+	}
+
+	rounds := make([]meta.ProfileRound, 0, len(groups))
+	for round, targets := range groups {
+		pr := meta.ProfileRound{Round: round}
+		for _, l := range targets {
+			pr.Profiles = append(pr.Profiles, *l)
+		}
+		sort.Slice(pr.Profiles, func(i, j int) bool {
+			ti, tj := pr.Profiles[i].Target, pr.Profiles[j].Target
+			if ti.Kind != tj.Kind {
+				return ti.Kind < tj.Kind
+			}
+			if ti.Component != tj.Component {
+				return ti.Component < tj.Component
+			}
+			return ti.Address < tj.Address
+		})
+		rounds = append(rounds, pr)
+	}
+	sort.Slice(rounds, func(i, j int) bool {
+		return rounds[i].Round < rounds[j].Round
+	})
+	return rounds
+}
+
 func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
@@ -62,8 +118,22 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 		Set("Content-Disposition",
 			fmt.Sprintf(`attachment; filename="profile"`+time.Now().Format("20060102150405")+".zip"))
 	zw := zip.NewWriter(w)
-	fn := func(pt meta.ProfileTarget, ts int64, data []byte) error {
-		fileName := fmt.Sprintf("%v_%v_%v_%v", pt.Kind, pt.Component, pt.Address, ts)
+	groupByRound := param != nil && param.GroupBy == meta.GroupByRound
+	// the metadata is built by the same pass of the profiles, the targets are in the order of the profiles.
+	var lists []meta.ProfileList
+	fn := func(pt meta.ProfileTarget, ts int64, attr meta.ProfileAttr, data []byte) error {
+		if len(lists) == 0 || lists[len(lists)-1].Target != pt {
+			lists = append(lists, meta.ProfileList{Target: pt})
+		}
+		list := &lists[len(lists)-1]
+		list.TsList = append(list.TsList, ts)
+		list.Attrs = append(list.Attrs, attr)
+
+		fileName := getProfileFileName(pt, ts, attr)
+		if groupByRound {
+			// put the profiles of the same round into the same directory.
+			fileName = fmt.Sprintf("round_%v/%v", attr.Round, fileName)
+		}
 		fw, err := zw.Create(fileName)
 		if err != nil {
 			return err
@@ -77,17 +147,165 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 		serveError(w, http.StatusInternalServerError, "query profile error: "+err.Error())
 		return
 	}
+	if len(lists) > 0 {
+		err = s.writeDownloadMetadata(zw, param, lists)
+		if err != nil {
+			log.Error("write download metadata failed", zap.Error(err))
+			return
+		}
+	}
 	err = zw.Close()
 	if err != nil {
 		log.Error("handle download request failed", zap.Error(err))
 	}
 }
 
+// downloadMetadataFileName is the file in the downloaded zip which describes the targets of the profiles.
+const downloadMetadataFileName = "metadata.json"
+
+// writeDownloadMetadata writes the metadata of the downloaded profiles into the zip, it contains the labels and the
+// build info of the targets, such as the version of TiDB.
+func (s *Server) writeDownloadMetadata(zw *zip.Writer, param *meta.BasicQueryParam, lists []meta.ProfileList) error {
+	for i := range lists {
+		list, err := s.store.QueryProfileTargetMeta(lists[i].Target, param.Begin, param.End)
+		if err != nil {
+			return err
+		}
+		list.TsList, list.Attrs = lists[i].TsList, lists[i].Attrs
+		lists[i] = list
+	}
+	metadata, err := json.MarshalIndent(lists, "", " ")
+	if err != nil {
+		return err
+	}
+	fw, err := zw.Create(downloadMetadataFileName)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(metadata)
+	return err
+}
+
+func getProfileFileName(pt meta.ProfileTarget, ts int64, attr meta.ProfileAttr) string {
+	// every part is sanitized, the target of the pushed profile is specified by the client.
+	fileName := fmt.Sprintf("%v_%v_%v_%v", sanitizeFileName(pt.Kind), sanitizeFileName(pt.Component),
+		sanitizeFileName(pt.Address), ts)
+	format := attr.Format
+	if format == "" && pt.Kind == meta.ProfileKindTrace {
+		format = meta.ProfileFormatTrace
+	}
+	switch format {
+	case meta.ProfileFormatProtobuf:
+		fileName += ".pb"
+	case meta.ProfileFormatText:
+		fileName += ".txt"
+	case meta.ProfileFormatTrace:
+		// the execution trace file can be opened by `go tool trace`.
+		fileName += ".trace"
+	case meta.ProfileFormatJemalloc:
+		fileName += ".heap"
+	case "":
+	default:
+		// the format declared in the config, such as perf.
+		fileName += "." + sanitizeFileName(format)
+	}
+	return fileName
+}
+
+// sanitizeFileName replaces the characters other than letters, digits, `_`, `-`, `.` and `:` with `_`, so the
+// name has no path separator and can't escape the directory when the zip is extracted.
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' ||
+			r == '.' || r == ':' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
 func (s *Server) handleComponents(w http.ResponseWriter, r *http.Request) {
 	components := s.scraper.GetCurrentScrapeComponents()
 	writeData(w, components)
 }
 
+func (s *Server) handleTargets(w http.ResponseWriter, r *http.Request) {
+	statuses := s.scraper.GetScrapeStatuses()
+	health := r.FormValue("health")
+	circuit := r.FormValue("circuit")
+	if health == "" && circuit == "" {
+		writeData(w, statuses)
+		return
+	}
+	filtered := make([]scrape.ScrapeStatus, 0, len(statuses))
+	for _, status := range statuses {
+		if health != "" && status.Health != health {
+			continue
+		}
+		if circuit != "" && status.Circuit != circuit {
+			continue
+		}
+		filtered = append(filtered, status)
+	}
+	writeData(w, filtered)
+}
+
+func (s *Server) handleCapture(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		break
+	default:
+		serveError(w, http.StatusBadRequest, "only support post")
+		return
+	}
+	param := &scrape.CaptureParam{}
+	err := json.NewDecoder(r.Body).Decode(param)
+	if err != nil {
+		serveError(w, http.StatusBadRequest, "parse capture param error: "+err.Error())
+		return
+	}
+	results, err := s.scraper.Capture(r.Context(), param)
+	if err != nil {
+		serveError(w, http.StatusBadRequest, "capture profile error: "+err.Error())
+		return
+	}
+	writeData(w, results)
+}
+
+func (s *Server) handleBurst(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeData(w, s.scraper.GetActiveBursts())
+	case http.MethodPost:
+		param := &scrape.BurstParam{}
+		err := json.NewDecoder(r.Body).Decode(param)
+		if err != nil {
+			serveError(w, http.StatusBadRequest, "parse burst param error: "+err.Error())
+			return
+		}
+		burst, err := s.scraper.StartBurst(param)
+		if err != nil {
+			serveError(w, http.StatusBadRequest, "start burst error: "+err.Error())
+			return
+		}
+		writeData(w, burst)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			serveError(w, http.StatusBadRequest, "params id value is invalid, should be int")
+			return
+		}
+		err = s.scraper.StopBurst(id)
+		if err != nil {
+			serveError(w, http.StatusBadRequest, "stop burst error: "+err.Error())
+			return
+		}
+		writeData(w, id)
+	default:
+		serveError(w, http.StatusBadRequest, "only support get, post and delete")
+	}
+}
+
 func (s *Server) handleEstimateSize(w http.ResponseWriter, r *http.Request) {
 	days := 0
 	if value := r.FormValue("days"); len(value) > 0 {
@@ -109,11 +327,15 @@ func (s *Server) handleEstimateSize(w http.ResponseWriter, r *http.Request) {
 		if size == 0 {
 			size = 500 * 1024
 		}
-		totalSize += size
+		// each profile kind is scraped at its own interval.
+		intervalSeconds := int(suite.GetInterval().Seconds())
+		if intervalSeconds <= 0 {
+			intervalSeconds = config.GetGlobalConfig().ContinueProfiling.IntervalSeconds
+		}
+		totalSize += (days * 24 * 60 * 60 / intervalSeconds) * size
 	}
-	cfg := config.GetGlobalConfig().ContinueProfiling
 	compressRatio := 10
-	estimateSize := (days * 24 * 60 * 60 / cfg.IntervalSeconds) * totalSize / compressRatio
+	estimateSize := totalSize / compressRatio
 	writeData(w, estimateSize)
 }
 
@@ -130,6 +352,13 @@ func (s *Server) getQueryParamFromBody(r *http.Request) (*meta.BasicQueryParam,
 	if err != nil {
 		return nil, err
 	}
+	if param.GroupBy != "" && param.GroupBy != meta.GroupByRound {
+		return nil, fmt.Errorf("unsupported group_by `%v`", param.GroupBy)
+	}
+	err = param.CompileLabelMatchers()
+	if err != nil {
+		return nil, err
+	}
 	return param, nil
 }
 
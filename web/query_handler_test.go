@@ -0,0 +1,86 @@
+package web
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/crazycs520/continuous-profile/config"
+	"github.com/crazycs520/continuous-profile/meta"
+	"github.com/crazycs520/continuous-profile/store"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetProfileFileName(t *testing.T) {
+	pt := meta.ProfileTarget{Kind: "perf", Component: "tikv", Address: "10.0.1.1:20180"}
+	require.Equal(t, "perf_tikv_10.0.1.1:20180_100.perf", getProfileFileName(pt, 100, meta.ProfileAttr{Format: "perf"}))
+	require.Equal(t, "perf_tikv_10.0.1.1:20180_100._.._.._etc_passwd",
+		getProfileFileName(pt, 100, meta.ProfileAttr{Format: "/../../etc/passwd"}))
+	// every part of the name is sanitized.
+	evil := meta.ProfileTarget{Kind: "../profile", Component: "a\\..\\b", Address: "x/../../../evil:80"}
+	fileName := getProfileFileName(evil, 100, meta.ProfileAttr{Format: meta.ProfileFormatProtobuf})
+	require.Equal(t, ".._profile_a_.._b_x_.._.._.._evil:80_100.pb", fileName)
+	require.NotContains(t, fileName, "/")
+	require.NotContains(t, fileName, "\\")
+	pt.Kind = meta.ProfileKindTrace
+	require.Equal(t, "trace_tikv_10.0.1.1:20180_100.trace", getProfileFileName(pt, 100, meta.ProfileAttr{}))
+}
+
+func TestDownload(t *testing.T) {
+	config.StoreGlobalConfig(config.NewConfig())
+	st, err := store.NewProfileStorage(t.TempDir())
+	require.NoError(t, err)
+	defer st.Close()
+	s := &Server{store: st}
+	tidb := meta.ProfileTarget{Kind: "goroutine", Component: "tidb", Address: "10.0.1.1:10080"}
+	tikv := meta.ProfileTarget{Kind: "goroutine", Component: "tikv", Address: "10.0.1.2:20180"}
+	require.NoError(t, st.AddProfile(tidb, 100, []byte("p1"), meta.ProfileAttr{Format: meta.ProfileFormatText}))
+	require.NoError(t, st.AddProfile(tidb, 200, []byte("p2"), meta.ProfileAttr{Format: meta.ProfileFormatText}))
+	require.NoError(t, st.AddProfile(tikv, 300, []byte("p3"), meta.ProfileAttr{Format: meta.ProfileFormatText}))
+	_, err = st.UpdateProfileTargetInfo(tidb, 200, map[string]string{"zone": "z1"})
+	require.NoError(t, err)
+	_, err = st.UpdateProfileTargetBuild(tidb, meta.BuildInfo{Version: "v5.3.0"}, 200)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/continuous-profiling/download", strings.NewReader(`{"begin_time":150,"end_time":400}`))
+	s.handleDownload(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		files[f.Name] = data
+	}
+	require.Len(t, files, 3)
+	require.Equal(t, []byte("p2"), files[getProfileFileName(tidb, 200, meta.ProfileAttr{Format: meta.ProfileFormatText})])
+
+	// the metadata only contains the targets and the profiles in the zip.
+	var lists []meta.ProfileList
+	require.NoError(t, json.Unmarshal(files[downloadMetadataFileName], &lists))
+	require.Len(t, lists, 2)
+	for _, list := range lists {
+		switch list.Target {
+		case tidb:
+			require.Equal(t, []int64{200}, list.TsList)
+			require.Equal(t, map[string]string{"zone": "z1"}, list.Labels)
+			require.Len(t, list.Builds, 1)
+			require.Equal(t, "v5.3.0", list.Builds[0].Version)
+		case tikv:
+			require.Equal(t, []int64{300}, list.TsList)
+			require.Len(t, list.Builds, 0)
+		default:
+			t.Fatalf("unexpected target %v", list.Target)
+		}
+	}
+}